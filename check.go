@@ -0,0 +1,54 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// weakOps describes the ops that the Generator only handles on a best-effort basis, so strings generated from
+// regexps containing them may not match.
+var weakOps = map[syntax.Op]string{
+	syntax.OpWordBoundary:   `word boundary (\b)`,
+	syntax.OpNoWordBoundary: `non-word boundary (\B)`,
+}
+
+// UnsupportedError is returned by Check for a regexp containing ops that the Generator only handles on a best-effort
+// basis, such as word boundaries.
+type UnsupportedError struct {
+	Regexp *syntax.Regexp
+	Ops    []syntax.Op // Best-effort ops in Regexp, each listed once in the order they first occur.
+}
+
+func (e *UnsupportedError) Error() string {
+	descs := make([]string, len(e.Ops))
+	for i, op := range e.Ops {
+		descs[i] = weakOps[op]
+	}
+	return fmt.Sprintf("regen: %v contains features that may not be satisfied: %s", e.Regexp, strings.Join(descs, ", "))
+}
+
+// Check returns an *UnsupportedError if rx contains ops that the Generator only handles on a best-effort basis, and
+// so may generate strings that don't match rx. Otherwise, it returns nil.
+func Check(rx *syntax.Regexp) error {
+	// Walk rx with a stack of its own instead of recursing, so that regexps of any depth can be checked.
+	var ops []syntax.Op
+	for stack := []*syntax.Regexp{rx}; len(stack) > 0; {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, weak := weakOps[top.Op]; weak && !slices.Contains(ops, top.Op) {
+			ops = append(ops, top.Op)
+		}
+		for i := len(top.Sub) - 1; i >= 0; i-- {
+			stack = append(stack, top.Sub[i])
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return &UnsupportedError{Regexp: rx, Ops: ops}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// charCount is a constraint on the number of times a character occurs in a generated string (-char-count).
+type charCount struct {
+	r  rune
+	op string // One of "=", "<=", or ">=".
+	n  int
+}
+
+// parseCharCount parses a -char-count constraint written as a character, an operator (=, <=, or >=), and a count,
+// such as @>=1 or .=1.
+func parseCharCount(v string) (charCount, error) {
+	r, size := utf8.DecodeRuneInString(v)
+	if size == 0 || r == utf8.RuneError && size == 1 {
+		return charCount{}, fmt.Errorf("missing character in %q", v)
+	}
+
+	c := charCount{r: r}
+	rest := v[size:]
+	for _, op := range []string{"<=", ">=", "="} {
+		if strings.HasPrefix(rest, op) {
+			c.op, rest = op, rest[len(op):]
+			break
+		}
+	}
+	if c.op == "" {
+		return charCount{}, fmt.Errorf("missing operator (=, <=, or >=) in %q", v)
+	}
+
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 0 {
+		return charCount{}, fmt.Errorf("invalid count in %q", v)
+	}
+	c.n = n
+	return c, nil
+}
+
+// ok returns whether s satisfies the constraint.
+func (c charCount) ok(s string) bool {
+	n := strings.Count(s, string(c.r))
+	switch c.op {
+	case "<=":
+		return n <= c.n
+	case ">=":
+		return n >= c.n
+	default:
+		return n == c.n
+	}
+}
+
+func (c charCount) String() string {
+	return fmt.Sprintf("%c%s%d", c.r, c.op, c.n)
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// examples writes the i-th pattern followed by up to n numbered strings generated for it by wk to w, for pasting into
+// documentation. Strings are generated the same as they are otherwise, so -starts-with and every check apply, and
+// fewer than n are written if some are skipped or generation stops (e.g., once -timeout passes). Strings with
+// characters that aren't printable are written as quoted Go strings so that they can be read.
+func examples(w io.Writer, wk *worker, i, n int) {
+	fmt.Fprintf(w, "%s\n", wk.patterns[i].label())
+	written := 0
+	for k := 0; k < n; k++ {
+		s, ok, done := wk.next(i)
+		if done {
+			return
+		} else if !ok {
+			continue
+		}
+		if strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 || s == "" {
+			s = strconv.Quote(s)
+		}
+		written++
+		fmt.Fprintf(w, "  example %d: %s\n", written, s)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp/syntax"
+
+	"go.spiff.io/regen"
+)
+
+// explain writes a summary of what gen can generate from rx to w: how many strings, how long they can be (and are on
+// average), and any parts of the pattern that regen only handles on a best-effort basis.
+func explain(w io.Writer, gen *regen.Generator, p pattern, rx *syntax.Regexp) {
+	fmt.Fprintf(w, "%v:\n", p)
+
+	n, infinite := gen.Count(rx)
+	if infinite {
+		fmt.Fprintf(w, "  strings: infinite (%v up to -max %d)\n", n, gen.UnboundMax)
+	} else {
+		fmt.Fprintf(w, "  strings: %v\n", n)
+	}
+
+	min, max := gen.Lengths(rx)
+	fmt.Fprintf(w, "  length: %d to %d bytes (mean %.2f)\n", min, max, gen.EstimateLength(rx))
+
+	for _, note := range explainNotes(rx) {
+		fmt.Fprintf(w, "  note: %s\n", note)
+	}
+}
+
+// explainNotes returns notes about the ops in rx that regen doesn't always generate a matching string for, once per
+// kind of op.
+func explainNotes(rx *syntax.Regexp) []string {
+	var notes []string
+	seen := make(map[string]bool)
+	var walk func(rx *syntax.Regexp)
+	walk = func(rx *syntax.Regexp) {
+		note := ""
+		switch rx.Op {
+		case syntax.OpNoMatch:
+			note = "contains a part that can't match anything, so branches and optional parts containing it are never " +
+				"chosen (and generating fails if it can't be avoided)"
+		case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+			note = `word boundaries (\b and \B) are best-effort and may not be satisfied`
+		case syntax.OpBeginLine, syntax.OpEndLine:
+			note = "line anchors (^ and $ in multi-line mode) write newlines where needed"
+		}
+		if note != "" && !seen[note] {
+			seen[note] = true
+			notes = append(notes, note)
+		}
+		for _, sub := range rx.Sub {
+			walk(sub)
+		}
+	}
+	walk(rx)
+	return notes
+}
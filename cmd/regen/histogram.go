@@ -0,0 +1,75 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.spiff.io/regen"
+)
+
+// histogramWidth is the width of the bar drawn for the most frequent bucket in a histogram.
+const histogramWidth = 40
+
+// histogram generates samples strings from rx using gen and writes a table of how often each rune was generated to w,
+// grouping runes into buckets of bucket consecutive code points (1 to count each rune separately). If ctx is
+// cancelled, the table only counts the strings generated before then, and ctx's error is returned after writing it.
+func histogram(ctx context.Context, w io.Writer, gen *regen.Generator, p pattern, rx *syntax.Regexp,
+	samples, bucket int) (err error) {
+	counts := make(map[rune]int)
+	total := 0
+	var buf bytes.Buffer
+	for i := 0; i < samples; i++ {
+		if err = ctx.Err(); err == nil {
+			buf.Reset()
+			err = gen.GenStringContext(ctx, &buf, rx)
+		}
+		if err != nil && ctx.Err() != nil {
+			samples = i
+			break
+		} else if err != nil {
+			return err
+		}
+		for _, r := range buf.String() {
+			counts[r/rune(bucket)*rune(bucket)]++
+			total++
+		}
+	}
+
+	keys := make([]rune, 0, len(counts))
+	most := 0
+	for k, n := range counts {
+		keys = append(keys, k)
+		most = max(most, n)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	fmt.Fprintf(w, "%v: %d runes from %d strings\n", p, total, samples)
+	for _, k := range keys {
+		n := counts[k]
+		label := fmt.Sprintf("%U %s", k, runeLabel(k))
+		if bucket > 1 {
+			label = fmt.Sprintf("%U-%U", k, k+rune(bucket)-1)
+		}
+		bar := strings.Repeat("#", max(1, n*histogramWidth/most))
+		fmt.Fprintf(w, "  %-18s %8d %6.2f%% %s\n", label, n, 100*float64(n)/float64(total), bar)
+	}
+	return err
+}
+
+// runeLabel returns r quoted if it's printable, or an empty string otherwise.
+func runeLabel(r rune) string {
+	if !unicode.IsPrint(r) {
+		return ""
+	}
+	return strconv.QuoteRune(r)
+}
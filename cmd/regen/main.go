@@ -0,0 +1,1087 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+// regen is a tool to parse and generate random strings from regular expressions.
+//
+//	go install go.spiff.io/regen/cmd/regen@latest
+//
+// regen works by parsing a regular expression and walking its op tree. It is currently not guaranteed to produce
+// entirely accurate results, but will at least try. The generator itself lives in the go.spiff.io/regen package.
+//
+// Word boundaries (\b and \B) are handled on a best-effort basis. In multi-line mode, ^ and $ write newlines where
+// needed to start or end a line.
+//
+// Usage is simple, pass one or more regular expressions to regen on the command line and it will generate a string from
+// each, printing them in the same order as on the command line (separated by newlines):
+//
+//	$ regen 'foo(-(bar|baz|quux|woop)){4}'
+//	foo-woop-quux-bar-quux
+//
+// So, if you fancy yourself a Javascript weirdo of some variety, you can at least use regen to write code for eBay:
+//
+//	$ regen '!{0,5}\[\](\[(!\[\](\+!{1,2}\[\]))\]|\+!{0,5}\[(\[\])?\]|\+\{\})+'
+//	![]+!!![[]]+{}[![]+!![]][![]+!![]]+{}[![]+![]]+{}+{}[![]+![]][![]+!![]]+![[]]+{}
+//
+// A few command-line options are provided, which you can see by running regen -help.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	mrand "math/rand"
+	"os"
+	"os/signal"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"go.spiff.io/regen"
+)
+
+// CLI options
+
+var verbose bool
+
+// maxN is the max number of strings that can be requested per pattern with -n.
+const maxN = math.MaxInt32
+
+// Line ending styles for -newline.
+const (
+	newlineLF   = "lf"
+	newlineCRLF = "crlf"
+	newlineNone = "none"
+)
+
+// Actions taken by -verify-fail.
+const (
+	failError = "error"
+	failWarn  = "warn"
+	failSkip  = "skip"
+)
+
+// Sources of labels for -label.
+const (
+	labelLine    = "line"
+	labelComment = "comment"
+)
+
+// Subcommands. Running regen without one is the same as running regen generate.
+const (
+	cmdGenerate = "generate"
+	cmdCount    = "count"
+	cmdExplain  = "explain"
+)
+
+const usageText = `
+regen [generate] [OPTIONS] <pattern>...
+regen count [OPTIONS] <pattern>...
+regen explain [OPTIONS] <pattern>...
+
+The generate command, which is the default, generates strings from each <pattern>. To generate
+strings from a pattern named generate, count, or explain, pass the command first (e.g., regen
+generate count).
+
+<pattern> must be a valid POSIX- or Perl-compatible RE2 regular expression pattern. RE2's
+regular expression syntax is described at <https://github.com/google/re2/wiki/Syntax>.
+
+A <pattern> on the command line may end in @<count> to generate <count> strings from it instead of
+-n, such as '\d{3}-\d{4}@5'. Escape the @ (\@) to end a pattern with a literal @ and digits.
+
+If <pattern> is "-" or -stdin is passed, patterns are read from standard input, one per line.
+Blank lines are skipped. Patterns may also be read from a file with -patterns-file, in which case
+lines starting with # are also skipped.
+
+With -template, a string is generated from a template, such as '{user}@{domain}', where each
+placeholder is filled by a pattern bound with -var (e.g., -var 'user=[a-z]{4,8}'). Use {{ and }}
+for literal braces. Each placeholder is generated on its own, so anchors in its pattern are ignored.
+
+With -grammar, strings are generated from a rule in a file of rules, one per line as name = pattern.
+Rules may refer to other rules, or themselves, as {name}, up to -grammar-depth references deep:
+
+    list = {item}(, {item})*
+    item = [a-z]+|\[{list}\]
+
+With -stream, strings are generated from each pattern in turn and written as they're generated until
+output is closed (e.g., by head exiting), at which point regen exits successfully. -n is ignored.
+
+With -all, every distinct string a pattern can match is written once, in order, instead of -n random
+strings, up to -max-results strings per pattern. Unbounded repetitions are counted up to -max, so
+keep it small: [a-z]* with -max 4 already matches nearly half a million strings.
+
+With -starts-with, each generated string starts with the given prefix, and only the part of its
+pattern left after the prefix is generated, so '[a-z]{3}-[0-9]{4}' with -starts-with ab generates
+strings like abx-1234. Patterns that can't match a string starting with the prefix are an error.
+
+With -label, each generated string is written after a label and a tab, so that strings from
+different patterns can be told apart. With -label line, the label is the line number of the string's
+pattern (or its position, for patterns given as arguments). With -label comment, it's the text of
+a # comment on the line just before the pattern in a -patterns-file, such as # email for:
+
+    # email
+    [a-z]{3,8}@example\.com
+
+Patterns without such a comment are labeled by line number.
+
+With -cover, each pattern generates as few strings as regen can find that together take every
+branch of every alternation in it, such as GET /users and POST /items for '(GET|POST)
+/(users|items)', instead of -n random strings. Branches that are only rarely reached, such as those
+inside optional parts, may take a few extra strings.
+
+With -negate, each generated string has a character inserted, replaced, or deleted and is retried
+until it no longer matches its pattern, for use as a negative sample. This is best-effort: some
+patterns, such as .*, match everything. -verify-attempts and -verify-fail apply to these retries.
+
+With -escape, non-printable characters in generated strings are written as Go escapes, such as
+\x00 or \u200b, so that output is safe to view in a terminal. Escaped strings will generally not
+match their pattern, and backslashes that are already in a string are not escaped.
+
+Patterns with parts that regen only supports on a best-effort basis, such as word boundaries (\b
+and \B), print a warning before generating strings that may not match them. With -strict, they're
+rejected instead.
+
+Note that when passing -simplify, this can convert {m,n} repetitions into chains of zero-or-one
+repetitions. The number of repetitions included from each chain is still chosen using -dist, the
+same as for the {m,n} repetition it came from.
+
+OPTIONS
+-------
+`
+
+const countUsageText = `
+regen count [OPTIONS] <pattern>...
+
+Prints how many strings each <pattern> can generate, counting unbounded repetitions up to -max.
+Ambiguous patterns, such as a|a, count each way of generating a string. Patterns are given the same
+way as for regen generate (see regen generate -h).
+
+OPTIONS
+-------
+`
+
+const explainUsageText = `
+regen explain [OPTIONS] <pattern>...
+
+Prints how many strings each <pattern> can generate, how long they are, and which parts of it are
+only supported on a best-effort basis. Patterns are given the same way as for regen generate (see
+regen generate -h).
+
+OPTIONS
+-------
+`
+
+func main() {
+	log.SetPrefix("regen: ")
+	log.SetFlags(0)
+
+	command, args := cmdGenerate, os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case cmdGenerate, cmdCount, cmdExplain:
+			command, args = args[0], args[1:]
+		}
+	}
+
+	// fs holds the flags for the command. Flags that only apply to generating strings are added to genFlags, which is
+	// only parsed by the generate command, so that they keep their defaults for other commands.
+	fs := flag.NewFlagSet("regen "+command, flag.ExitOnError)
+	genFlags := fs
+	if command != cmdGenerate {
+		genFlags = flag.NewFlagSet(command, flag.ContinueOnError)
+	}
+	fs.Usage = func() {
+		usage := usageText
+		switch command {
+		case cmdCount:
+			usage = countUsageText
+		case cmdExplain:
+			usage = explainUsageText
+		}
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(usage))
+		fs.PrintDefaults()
+	}
+
+	gen := regen.New()
+
+	simplify := fs.Bool("simplify", false, "Whether to simplify the parsed regular expressions.")
+	posix := fs.Bool("posix", false, "Use POSIX syntax instead of Perl-like syntax.")
+	zip := genFlags.Bool("zip", false, "Whether to interleave patterns or go pattern by pattern.")
+	n := genFlags.Int("n", 1, "The `number` of strings to generate per regexp.")
+	fs.IntVar(&gen.UnboundMin, "min-repeat", 0, "The min `repetitions` to use for unlimited repetitions/matches.")
+	genFlags.IntVar(&gen.MaxDepth, "max-depth", 0, "The max `depth` that patterns may be nested (0 for no limit).")
+	fs.IntVar(&gen.RepeatBudget, "repeat-cap", 0,
+		"The max `product` of the repetition counts of nested repetitions (0 for no limit).")
+	fs.IntVar(&gen.UnboundMax, "max", gen.UnboundMax, "The max `repetitions` to use for unlimited repetitions/matches.")
+	genFlags.IntVar(&gen.MinLen, "minlen", 0, "The min `length` in bytes of generated strings (0 for no limit).")
+	genFlags.IntVar(&gen.MaxLen, "maxlen", 0, "The max `length` in bytes of generated strings (0 for no limit).")
+	genFlags.BoolVar(&gen.NoLeadingMarks, "no-leading-marks", false,
+		"Whether to generate strings again if they start with a combining mark (e.g., with -unicode).")
+	genFlags.BoolVar(&gen.NormalizeNFC, "nfc", false,
+		"Whether to NFC-normalize generated strings, composing combining marks with the characters before them.")
+	genFlags.BoolVar(&gen.CountRunes, "count-runes", false, "Whether -minlen and -maxlen count runes instead of bytes.")
+	fs.BoolVar(&gen.Unicode, "unicode", false, "Whether . generates any Unicode code point instead of printable ASCII.")
+	dotCharset := fs.String("dot-charset", "", "A char `class` (e.g., [a-z0-9]) to generate characters for . from.")
+	dotNewline := fs.String("dot-newline", "",
+		"A `character` for . to generate in place of a newline in dot-all mode ((?s)), including from -dot-charset.")
+	genFlags.BoolVar(&gen.Bytes, "bytes", false,
+		"Whether char classes and . generate raw bytes (\\x00 to \\xff) instead of UTF-8 encoded characters.")
+	fs.BoolVar(&gen.ASCII, "ascii", false, "Whether to restrict generated characters to printable ASCII.")
+	fs.Float64Var(&gen.QuestProb, "quest-prob", gen.QuestProb, "The `probability` (0 to 1) of including optional parts.")
+	genFlags.Float64Var(&gen.NewlineProb, "newline-prob", gen.NewlineProb,
+		"The `probability` (0 to 1) of . generating a newline in dot-all mode ((?s)).")
+	fs.Func("dist", "The `distribution` of repetition counts (uniform, geometric, or max-biased).",
+		func(name string) (err error) {
+			gen.Dist, err = regen.ParseDistribution(name)
+			return err
+		})
+	stream := genFlags.Bool("stream", false,
+		"Whether to generate strings from each pattern in turn until output is closed, ignoring -n.")
+	timeout := genFlags.Duration("timeout", 0, "The max `duration` to spend generating strings (0 for no limit).")
+	timeoutPartial := genFlags.Bool("timeout-partial", false,
+		"Whether to write the strings generated so far once -timeout passes instead of exiting with an error.")
+	jobs := genFlags.Int("jobs", 1,
+		"The `number` of patterns to generate strings for concurrently. Doesn't change the strings generated.")
+	seed := genFlags.Int64("seed", 0, "The `seed` to use for reproducible output. Implies -rand-source math.")
+	randSource := genFlags.String("rand-source", sourceCrypto,
+		"The `source` of randomness: crypto (crypto/rand), math (math/rand, faster), or urandom (/dev/urandom).")
+	jsonOut := genFlags.Bool("json", false,
+		"Whether to write results as a JSON object mapping patterns to strings (or an array of rounds, with -zip).")
+	jsonCaptures := genFlags.Bool("json-captures", false,
+		"Whether to write each result as an object holding its value and its capture groups' text with -json.")
+	nul := genFlags.Bool("0", false, "Whether to separate generated strings with NUL bytes instead of newlines.")
+	join := genFlags.String("join", "",
+		"A `string` to separate generated strings with instead of newlines (or NUL bytes, with -0).")
+	prefix := genFlags.String("prefix", "", "A `string` to write before each generated string.")
+	labelBy := genFlags.String("label", "",
+		"The `source` of a label to write before each string and a tab: line (its pattern's line) or comment.")
+	suffix := genFlags.String("suffix", "", "A `string` to write after each generated string.")
+	startsWith := genFlags.String("starts-with", "",
+		"A `prefix` that generated strings must start with. Only the rest of each pattern is generated.")
+	genFlags.Func("freq",
+		"A rune frequency `table` to weight characters by: english, or a file of '<char> <weight>' lines.",
+		func(v string) (err error) {
+			gen.Frequencies, err = loadFrequencies(v)
+			return err
+		})
+	genFlags.BoolVar(&gen.CycleAlternates, "cycle-alternates", false,
+		"Whether to generate each alternation's branches in turn instead of choosing them at random.")
+	genFlags.BoolVar(&gen.TrimAnchorNewlines, "trim", false,
+		"Whether to omit newlines that are only written to satisfy ^ and $ in multi-line mode.")
+	newline := genFlags.String("newline", "",
+		"The `style` to normalize line endings in generated strings to: lf, crlf, or none (remove them).")
+	escape := genFlags.Bool("escape", false,
+		"Whether to write non-printable characters in generated strings as Go escapes (e.g., \\x00).")
+	shellQuoted := genFlags.Bool("shell-quote", false,
+		"Whether to write each generated string in single quotes, as a single shell argument.")
+	output := genFlags.String("output", "", "The `file` to write generated strings to instead of standard output.")
+	patternsFile := fs.String("patterns-file", "",
+		"A `file` to read patterns from, one per line. Lines starting with # are ignored.")
+	template := fs.String("template", "",
+		"A `template` to generate, with {name} placeholders filled by patterns given with -var.")
+	vars := map[string]string{}
+	fs.Func("var", "A `name=pattern` binding for a -template placeholder. May be repeated.", func(v string) error {
+		name, pat, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("expected name=pattern, got %q", v)
+		}
+		vars[name] = pat
+		return nil
+	})
+	var matchAlso, matchNever []string
+	genFlags.Func("match-also", "A `pattern` that every generated string must also match. May be repeated.",
+		func(v string) error {
+			matchAlso = append(matchAlso, v)
+			return nil
+		})
+	var charCounts []charCount
+	genFlags.Func("char-count",
+		"A `constraint` on how many times a character occurs, such as @>=1 or .=1, retried like -verify. May be repeated.",
+		func(v string) error {
+			c, err := parseCharCount(v)
+			if err == nil {
+				charCounts = append(charCounts, c)
+			}
+			return err
+		})
+	genFlags.Func("match-never", "A `pattern` that no generated string may match. May be repeated.", func(v string) error {
+		matchNever = append(matchNever, v)
+		return nil
+	})
+	grammarFile := fs.String("grammar", "",
+		"A `file` of rules, one per line as name = pattern, to generate from. Rules refer to others as {name}.")
+	rule := fs.String("rule", "", "The `name` of the -grammar rule to generate from (default the first rule).")
+	grammarDepth := fs.Int("grammar-depth", regen.DefaultGrammarDepth,
+		"The max `depth` of rule references to expand with -grammar.")
+	stdin := fs.Bool("stdin", false, "Whether to read patterns from standard input, one per line.")
+	explainOnly := genFlags.Bool("explain", false,
+		"Whether to print how many strings each pattern can generate, their lengths, and best-effort parts, and exit.")
+	count := genFlags.Bool("count", false, "Whether to print how many strings each pattern can generate and exit.")
+	histogramOnly := genFlags.Bool("histogram", false,
+		"Whether to print how often each rune is generated by each pattern to stderr, instead of generating strings.")
+	examplesN := genFlags.Int("examples", 0,
+		"The `number` of numbered example strings to print under each pattern, for documentation (0 to not).")
+	histogramSamples := genFlags.Int("histogram-samples", 10000, "The `number` of strings to generate with -histogram.")
+	histogramBucket := genFlags.Int("histogram-bucket", 1,
+		"The `size` of the code point ranges to group runes into with -histogram (1 for each rune).")
+	all := genFlags.Bool("all", false,
+		"Whether to generate every distinct string each pattern can match, up to -max repetitions, instead of -n.")
+	maxResults := genFlags.Int("max-results", 1000,
+		"The max `number` of strings to generate for each pattern with -all (0 for no limit).")
+	cover := genFlags.Bool("cover", false,
+		"Whether to generate a few strings per pattern that together take every alternation branch, instead of -n.")
+	literalFallback := fs.Bool("literal-fallback", false,
+		"Whether to treat patterns that fail to parse as literal strings instead of exiting.")
+	keepGoing := fs.Bool("keep-going", false,
+		"Whether to skip patterns that fail to parse instead of exiting. Exits with status 1 if any failed.")
+	quiet := fs.Bool("quiet", false,
+		"Whether to silence warnings and errors and never add a trailing newline when writing to a terminal.")
+	strict := fs.Bool("strict", false,
+		"Whether to reject patterns with best-effort parts, such as word boundaries, instead of warning about them.")
+	unique := genFlags.Bool("unique", false, "Whether to only generate unique strings for each pattern.")
+	uniqueAttempts := genFlags.Int("unique-attempts", 100,
+		"The max consecutive duplicate `attempts` with -unique before giving up on a pattern.")
+	noEmpty := genFlags.Bool("no-empty", false, "Whether to regenerate empty strings instead of writing them.")
+	emptyAttempts := genFlags.Int("empty-attempts", 100,
+		"The max consecutive empty `attempts` with -no-empty before failing.")
+	report := genFlags.Bool("report", false,
+		"Whether to print how many strings were generated, their lengths, and retries to stderr when done.")
+	fs.BoolVar(&verbose, "verbose", false, "Whether to print op trees and choices made during generation to stderr.")
+	verify := genFlags.Bool("verify", false,
+		"Whether to check that generated strings match their pattern, retrying if not.")
+	fullmatch := genFlags.Bool("fullmatch", false,
+		"Whether to check that generated strings match their whole pattern, retrying if not. Implies -verify.")
+	negate := genFlags.Bool("negate", false,
+		"Whether to generate strings that don't match their pattern by mutating generated strings.")
+	verifyAttempts := genFlags.Int("verify-attempts", 10, "The max `attempts` to generate a matching string with -verify.")
+	verifyFail := genFlags.String("verify-fail", failError,
+		"The `action` to take when -verify fails: error (exit), warn (print anyway), or skip.")
+	fs.Parse(args)
+
+	switch command {
+	case cmdCount:
+		*count = true
+	case cmdExplain:
+		*explainOnly = true
+	}
+
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	if *n < 0 || *n > maxN {
+		log.Printf("invalid -n %d: must be between 0 and %d", *n, maxN)
+		os.Exit(2)
+	}
+
+	if gen.QuestProb < 0 || gen.QuestProb > 1 {
+		log.Printf("invalid -quest-prob %v: must be between 0 and 1", gen.QuestProb)
+		os.Exit(2)
+	}
+	if *timeout < 0 {
+		log.Printf("invalid -timeout %v: must not be negative", *timeout)
+		os.Exit(2)
+	}
+	if gen.NewlineProb < 0 || gen.NewlineProb > 1 {
+		log.Printf("invalid -newline-prob %v: must be between 0 and 1", gen.NewlineProb)
+		os.Exit(2)
+	}
+	if gen.MaxDepth < 0 {
+		log.Printf("invalid -max-depth %d: must not be negative", gen.MaxDepth)
+		os.Exit(2)
+	}
+	if gen.RepeatBudget < 0 {
+		log.Printf("invalid -repeat-cap %d: must not be negative", gen.RepeatBudget)
+		os.Exit(2)
+	}
+	if gen.UnboundMax < 0 {
+		log.Printf("invalid -max %d: must not be negative", gen.UnboundMax)
+		os.Exit(2)
+	}
+	if gen.UnboundMin < 0 {
+		log.Printf("invalid -min-repeat %d: must not be negative", gen.UnboundMin)
+		os.Exit(2)
+	}
+
+	switch *verifyFail {
+	case failError, failWarn, failSkip:
+	default:
+		log.Printf("invalid -verify-fail action %q", *verifyFail)
+		os.Exit(2)
+	}
+
+	switch *newline {
+	case "", newlineLF, newlineCRLF, newlineNone:
+	default:
+		log.Printf("invalid -newline style %q", *newline)
+		os.Exit(2)
+	}
+
+	if *stream && *jsonOut {
+		log.Println("-stream can't be used with -json")
+		os.Exit(2)
+	}
+	switch *labelBy {
+	case "", labelLine, labelComment:
+	default:
+		log.Printf("invalid -label source %q", *labelBy)
+		os.Exit(2)
+	}
+	if *labelBy != "" && *jsonOut {
+		log.Println("-label can't be used with -json")
+		os.Exit(2)
+	}
+	if *jsonCaptures && (!*jsonOut || *all || *cover || *negate) {
+		log.Println("-json-captures requires -json and can't be used with -all, -cover, or -negate")
+		os.Exit(2)
+	}
+	if *all && (*stream || *zip || *negate) {
+		log.Println("-all can't be used with -stream, -zip, or -negate")
+		os.Exit(2)
+	}
+	if *cover && (*all || *stream || *zip || *negate) {
+		log.Println("-cover can't be used with -all, -stream, -zip, or -negate")
+		os.Exit(2)
+	}
+	if *examplesN < 0 {
+		log.Println("-examples must not be negative")
+		os.Exit(2)
+	}
+	if *histogramSamples < 1 || *histogramBucket < 1 {
+		log.Println("-histogram-samples and -histogram-bucket must be at least 1")
+		os.Exit(2)
+	}
+	if *maxResults < 0 {
+		log.Printf("invalid -max-results %d: must not be negative", *maxResults)
+		os.Exit(2)
+	}
+
+	if verbose {
+		gen.Debug = os.Stderr
+	}
+
+	mode := syntax.Perl
+	if *posix {
+		mode = syntax.POSIX
+	}
+
+	if *dotNewline != "" {
+		r, size := utf8.DecodeRuneInString(*dotNewline)
+		if r == utf8.RuneError || size != len(*dotNewline) {
+			log.Printf("invalid -dot-newline %q: must be a single character", *dotNewline)
+			os.Exit(2)
+		}
+		gen.NewlineRune = r
+	}
+	if *dotCharset != "" {
+		var err error
+		if gen.DotRanges, err = parseCharset(*dotCharset, mode); err != nil {
+			log.Printf("invalid -dot-charset: %v", errText(err))
+			os.Exit(2)
+		}
+	}
+
+	seeded, sourceSet, joined := false, false, false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "seed":
+			seeded = true
+		case "rand-source":
+			sourceSet = true
+		case "join":
+			joined = true
+		}
+	})
+	if seeded && sourceSet && *randSource != sourceMath {
+		log.Printf("-seed can't be used with -rand-source %s", *randSource)
+		os.Exit(2)
+	}
+
+	switch *randSource {
+	case sourceCrypto:
+	case sourceMath:
+		if !seeded {
+			// Pick a seed so that -jobs can give each pattern its own source, the same as with -seed.
+			s, err := randomSeed()
+			if err != nil {
+				log.Printf("error seeding math/rand: %v", err)
+				os.Exit(1)
+			}
+			*seed, seeded = s, true
+		}
+	case sourceURandom:
+		r, err := openURandom()
+		if err != nil {
+			log.Printf("error opening /dev/urandom: %v", err)
+			os.Exit(1)
+		}
+		gen.Rand = r
+	default:
+		log.Printf("invalid -rand-source %q", *randSource)
+		os.Exit(2)
+	}
+	if seeded {
+		gen.Rand = mrand.New(mrand.NewSource(*seed))
+	}
+
+	var patterns []pattern
+	readStdin := func() {
+		ps, err := readPatterns(os.Stdin, "stdin", false)
+		if err != nil {
+			log.Printf("error reading patterns from stdin: %v", err)
+			os.Exit(1)
+		}
+		patterns = append(patterns, ps...)
+		*stdin = false
+	}
+	for _, s := range fs.Args() {
+		if s == "-" {
+			readStdin()
+			continue
+		}
+		p, err := parseArg(s)
+		if err != nil {
+			log.Printf("invalid pattern %q: %v", s, errText(err))
+			os.Exit(2)
+		}
+		patterns = append(patterns, p)
+	}
+	if *stdin {
+		readStdin()
+	}
+	if *patternsFile != "" {
+		ps, err := readPatternsFile(*patternsFile)
+		if err != nil {
+			log.Printf("error reading patterns file: %v", err)
+			os.Exit(1)
+		}
+		patterns = append(patterns, ps...)
+	}
+
+	if *template != "" {
+		rx, err := regen.ParseTemplate(*template, vars, mode)
+		if err != nil {
+			log.Printf("invalid -template: %v", errText(err))
+			os.Exit(2)
+		}
+		patterns = append(patterns, pattern{expr: rx.String(), rx: rx, name: *template})
+	} else if len(vars) > 0 {
+		log.Println("-var given without -template")
+		os.Exit(2)
+	}
+
+	if *grammarFile != "" {
+		p, err := grammarPattern(*grammarFile, *rule, *grammarDepth, mode)
+		if err != nil {
+			log.Printf("error loading grammar: %v", errText(err))
+			os.Exit(1)
+		}
+		patterns = append(patterns, p)
+	} else if *rule != "" {
+		log.Println("-rule given without -grammar")
+		os.Exit(2)
+	}
+
+	// Patterns without a count of their own generate -n strings.
+	for i := range patterns {
+		if !patterns[i].hasN {
+			patterns[i].n = *n
+		}
+	}
+
+	if len(patterns) == 0 {
+		log.Println("no regexp given")
+		return
+	}
+
+	compile := regexp.Compile
+	if *posix {
+		compile = regexp.CompilePOSIX
+	}
+
+	var also, never []*regexp.Regexp
+	for _, expr := range matchAlso {
+		re, err := compile(expr)
+		if err != nil {
+			log.Printf("invalid -match-also %q: %v", expr, err)
+			os.Exit(2)
+		}
+		also = append(also, re)
+	}
+	for _, expr := range matchNever {
+		re, err := compile(expr)
+		if err != nil {
+			log.Printf("invalid -match-never %q: %v", expr, err)
+			os.Exit(2)
+		}
+		never = append(never, re)
+	}
+
+	// failed is set if any pattern failed to parse with -keep-going.
+	failed := false
+	var (
+		parsed   []pattern
+		regexen  []*syntax.Regexp
+		matchers []*regexp.Regexp
+	)
+	for _, p := range patterns {
+		rx := p.rx
+		var err error
+		if rx == nil {
+			rx, err = regen.Parse(p.expr, mode)
+		}
+		if err != nil && *literalFallback {
+			// Generate the pattern as a literal string, but keep showing it as it was given.
+			if p.name == "" {
+				p.name = p.expr
+			}
+			p.expr = regexp.QuoteMeta(p.expr)
+			rx, err = regen.Parse(p.expr, mode)
+		}
+		if perr := (*regen.ParseError)(nil); errors.As(err, &perr) {
+			log.Printf("error parsing regular expression %v:\n%v", p, perr.Err)
+			if !*keepGoing {
+				os.Exit(1)
+			}
+			failed = true
+			continue
+		}
+		// Check -max-depth here so that it applies to -count and -explain as well as to generating strings.
+		if err := gen.CheckDepth(rx); err != nil {
+			log.Printf("error checking regular expression %v:\n%v", p, errText(err))
+			if !*keepGoing {
+				os.Exit(1)
+			}
+			failed = true
+			continue
+		}
+
+		if *simplify {
+			// Simplify here as well as in the Generator so that -count, -explain, and -verbose describe the
+			// simplified op tree.
+			rx = rx.Simplify()
+			gen.Simplify = true
+		}
+
+		if *verify || *negate || *fullmatch {
+			var re *regexp.Regexp
+			if *fullmatch {
+				// Anchor the parsed pattern at both ends of the text, written in Perl syntax so that \A and \z are
+				// available even with -posix.
+				re, err = regexp.Compile(`\A(?:` + rx.String() + `)\z`)
+			} else if p.rx != nil {
+				re, err = regexp.Compile(p.expr) // Written from p.rx in Perl syntax.
+			} else {
+				// Remove comments the same as regen.Parse does, since regexp doesn't support them either.
+				expr := p.expr
+				if !*posix {
+					expr = regen.StripComments(expr)
+				}
+				re, err = compile(expr)
+			}
+			if err != nil {
+				log.Printf("error compiling regular expression %v:\n%v", p, err)
+				if !*keepGoing {
+					os.Exit(1)
+				}
+				failed = true
+				continue
+			}
+			matchers = append(matchers, re)
+		}
+
+		if err := regen.Check(rx); err != nil && !*explainOnly {
+			if *strict {
+				log.Printf("error checking regular expression %v:\n%v", p, errText(err))
+				if !*keepGoing {
+					os.Exit(1)
+				}
+				failed = true
+				continue
+			}
+			log.Printf("warning: %v", errText(err))
+		}
+
+		if *startsWith != "" {
+			rest, err := regen.AfterPrefix(rx, *startsWith)
+			if err != nil {
+				log.Printf("error applying -starts-with to regular expression %v:\n%v", p, errText(err))
+				if !*keepGoing {
+					os.Exit(1)
+				}
+				failed = true
+				continue
+			}
+			rx = rest
+		}
+
+		if _, longest := gen.Lengths(rx); *noEmpty && *startsWith == "" && longest == 0 {
+			log.Printf("regular expression %v can only generate the empty string, which -no-empty excludes", p)
+			if !*keepGoing {
+				os.Exit(1)
+			}
+			failed = true
+			continue
+		}
+
+		if verbose {
+			fmt.Fprintf(os.Stderr, "op tree for %v:\n", p)
+			dumpTree(os.Stderr, rx, 1)
+		}
+
+		parsed = append(parsed, p)
+		regexen = append(regexen, rx)
+	}
+	patterns = parsed
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	b := &batch{
+		patterns:       patterns,
+		regexen:        regexen,
+		matchers:       matchers,
+		ctx:            ctx,
+		timeout:        *timeout,
+		timeoutPartial: *timeoutPartial,
+		matchAlso:      also,
+		matchNever:     never,
+		startsWith:     *startsWith,
+		charCounts:     charCounts,
+		negate:         *negate,
+		verifyAttempts: *verifyAttempts,
+		verifyFail:     *verifyFail,
+		uniqueAttempts: *uniqueAttempts,
+		noEmpty:        *noEmpty,
+		emptyAttempts:  *emptyAttempts,
+	}
+	if *unique {
+		b.seen = make([]map[string]struct{}, len(regexen))
+		for i := range b.seen {
+			b.seen[i] = make(map[string]struct{})
+		}
+	}
+	if *jsonCaptures {
+		b.captures = make([][]map[string]string, len(regexen))
+	}
+
+	if *explainOnly {
+		for i, rx := range regexen {
+			explain(os.Stdout, gen, patterns[i], rx)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *histogramOnly {
+		for i, rx := range regexen {
+			err := histogram(ctx, os.Stderr, gen, patterns[i], rx, *histogramSamples, *histogramBucket)
+			if b.stopped(err) {
+				break
+			} else if err != nil {
+				log.Printf("error generating string for %v: %v", patterns[i], errText(err))
+				os.Exit(1)
+			}
+		}
+		if b.timedOut.Load() {
+			log.Printf("timed out after %v: only counted the strings generated so far", *timeout)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *examplesN > 0 {
+		wk := &worker{batch: b, gen: gen}
+		for i := range regexen {
+			if i > 0 {
+				fmt.Println()
+			}
+			if examples(os.Stdout, wk, i, *examplesN); b.timedOut.Load() {
+				break
+			}
+		}
+		if b.timedOut.Load() {
+			log.Printf("timed out after %v: only wrote the examples generated so far", *timeout)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *count {
+		for i, rx := range regexen {
+			n, infinite := gen.Count(rx)
+			if infinite {
+				fmt.Printf("infinite (%v up to -max %d)\t%s\n", n, gen.UnboundMax, patterns[i].label())
+			} else {
+				fmt.Printf("%v\t%s\n", n, patterns[i].label())
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// rounds is the largest number of strings to generate for a pattern.
+	rounds := 0
+	for _, p := range patterns {
+		rounds = max(rounds, p.n)
+	}
+
+	if rounds == 0 && !*stream && !*all && !*cover {
+		// Nothing to generate, so don't write anything (including a trailing newline).
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		var err error
+		file, err = os.Create(*output)
+		if err != nil {
+			log.Printf("error opening output file: %v", err)
+			os.Exit(1)
+		}
+		w = file
+	}
+	out := bufio.NewWriter(w)
+
+	sep := "\n"
+	if joined {
+		sep = *join
+	} else if *nul {
+		sep = "\x00"
+	} else if *newline == newlineCRLF {
+		sep = "\r\n"
+	}
+
+	// With -json, results are collected per pattern (or per round, with -zip) and written once generation is done.
+	var results [][]any
+	if *jsonOut && !*zip {
+		results = make([][]any, len(regexen))
+		for i := range results {
+			results[i] = []any{}
+		}
+	}
+
+	// With -zip, each round of strings is started lazily, so that rounds where every pattern is done or skipped
+	// aren't written as empty arrays with -json.
+	first, newRound := true, false
+	startRound := func() {
+		newRound = true
+	}
+	format := func(i int, s string) string {
+		if *newline != "" {
+			s = normalizeNewlines(s, *newline)
+		}
+		if *escape {
+			s = escapeString(s)
+		}
+		if *shellQuoted {
+			s = shellQuote(s)
+		}
+		s = *prefix + s + *suffix
+		if *labelBy != "" {
+			s = patterns[i].lineLabel(*labelBy, i+1) + "\t" + s
+		}
+		return s
+	}
+	// written counts the strings written for each pattern, to find their captures with -json-captures.
+	written := make([]int, len(regexen))
+	write := func(i int, s string) {
+		s = format(i, s)
+		if *jsonOut {
+			var result any = s
+			if *jsonCaptures {
+				result = captured{Value: s, Captures: b.captures[i][written[i]]}
+			}
+			written[i]++
+			if *zip {
+				if newRound {
+					results = append(results, []any{})
+					newRound = false
+				}
+				i = len(results) - 1
+			}
+			results[i] = append(results[i], result)
+			return
+		}
+
+		if !first {
+			out.WriteString(sep)
+		}
+		first = false
+		out.WriteString(s)
+	}
+
+	// st holds the stats of everything generated, for -report.
+	var st stats
+	if *stream {
+		// Ignore SIGPIPE so that writing to a closed pipe returns EPIPE, which just means the reader is done.
+		signal.Ignore(syscall.SIGPIPE)
+		wk := &worker{batch: b, gen: gen}
+		err := wk.stream(out, format, sep)
+		if *report {
+			wk.stats.write(os.Stderr)
+		}
+		if errors.Is(err, syscall.EPIPE) {
+			os.Exit(0)
+		} else if err != nil {
+			log.Printf("error writing output: %v", err)
+			os.Exit(1)
+		}
+	} else if *all {
+		// Strings are filtered by the same checks as generated strings, so -max-results counts the strings kept, not
+		// the strings enumerated.
+		errMaxResults := errors.New("enough results")
+		wk := &worker{batch: b, gen: gen}
+		for j, rx := range regexen {
+			kept := 0
+			err := gen.Enumerate(rx, 0, func(s string) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				s = *startsWith + s
+				if !wk.keep(j, s) {
+					return nil
+				}
+				write(j, s)
+				if kept++; *maxResults > 0 && kept >= *maxResults {
+					return errMaxResults
+				}
+				return nil
+			})
+			if b.stopped(err) {
+				break
+			} else if err != nil && err != errMaxResults {
+				log.Printf("error enumerating strings for %v: %v", patterns[j], errText(err))
+				os.Exit(1)
+			}
+		}
+		st = wk.stats
+	} else if *cover {
+		wk := &worker{batch: b, gen: gen}
+		for j, rx := range regexen {
+			strs, err := gen.CoverAlternatesContext(ctx, rx)
+			stopped := b.stopped(err)
+			if err != nil && !stopped {
+				log.Printf("error generating string for %v: %v", patterns[j], errText(err))
+				os.Exit(1)
+			}
+			for _, s := range strs {
+				if s = *startsWith + s; wk.keep(j, s) {
+					write(j, s)
+				}
+			}
+			if stopped {
+				break
+			}
+		}
+		st = wk.stats
+	} else {
+		// Each pattern gets its own source of randomness when seeded, so the strings generated only depend on the seed
+		// and not on -jobs.
+		var newRand func(int) io.Reader
+		if seeded {
+			newRand = func(i int) io.Reader {
+				return mrand.New(mrand.NewSource(*seed + int64(i)))
+			}
+		}
+
+		var generated [][]string
+		generated, st = b.generateAll(gen, *jobs, newRand)
+		if *zip {
+			// Patterns may generate fewer strings than their count (e.g., with -unique), so only go as many rounds
+			// as the most strings generated.
+			rounds = 0
+			for _, strs := range generated {
+				rounds = max(rounds, len(strs))
+			}
+			for i := 0; i < rounds; i++ {
+				startRound()
+				for j, strs := range generated {
+					if i < len(strs) {
+						write(j, strs[i])
+					}
+				}
+			}
+		} else {
+			for j, strs := range generated {
+				for _, s := range strs {
+					write(j, s)
+				}
+			}
+		}
+	}
+
+	if *jsonOut {
+		if err := writeJSON(out, patterns, results, *zip); err != nil {
+			log.Printf("error writing JSON: %v", err)
+			os.Exit(1)
+		}
+	} else if file == nil && !*stream && !*quiet && isTerminal(os.Stdout) {
+		if joined {
+			out.WriteString("\n")
+		} else {
+			out.WriteString(sep)
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		log.Printf("error writing output: %v", err)
+		os.Exit(1)
+	}
+	if b.timedOut.Load() {
+		log.Printf("timed out after %v: only wrote the strings generated so far", *timeout)
+	}
+	if *report {
+		st.write(os.Stderr)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			log.Printf("error closing output file: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// errText returns the text of err without the "regen: " prefix of errors from the regen package, which the log prefix
+// already has.
+func errText(err error) string {
+	return strings.TrimPrefix(err.Error(), "regen: ")
+}
+
+// isTerminal attempts to determine whether f (usually stdout) refers to a terminal. Terminals are character devices,
+// while pipes and regular files aren't, so output redirected to either doesn't get a trailing newline. Other character
+// devices, such as /dev/null, are treated as terminals, which only costs them a newline.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		log.Printf("Error getting Stat of %s: %v", f.Name(), err)
+		return true // Assume human readable
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
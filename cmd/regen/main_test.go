@@ -0,0 +1,153 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"testing"
+
+	"go.spiff.io/regen"
+)
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(t *testing.T) *os.File
+		want bool
+	}{
+		{"file", func(t *testing.T) *os.File {
+			f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}, false},
+		{"pipe", func(t *testing.T) *os.File {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { r.Close() })
+			return w
+		}, false},
+		{"char device", func(t *testing.T) *os.File {
+			f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			if err != nil {
+				t.Skip(err)
+			}
+			return f
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.open(t)
+			defer f.Close()
+			if got := isTerminal(f); got != tt.want {
+				t.Errorf("isTerminal(%s) = %t; want %t", f.Name(), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrText(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("regen: a|b can't match anything"), "a|b can't match anything"},
+		{errors.New("open x: no such file or directory"), "open x: no such file or directory"},
+		{fmt.Errorf("regen: %w", errors.New("regen: nested")), "regen: nested"},
+	}
+	for _, tt := range tests {
+		if got := errText(tt.err); got != tt.want {
+			t.Errorf("errText(%q) = %q; want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateAllJobs(t *testing.T) {
+	exprs := []string{`[a-z]{5}`, `[0-9]{4}`, `x|y|z`, `(?:ab)*`}
+	b := &batch{ctx: context.Background()}
+	for _, expr := range exprs {
+		rx, err := regen.Parse(expr, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.patterns = append(b.patterns, pattern{expr: expr, n: 10})
+		b.regexen = append(b.regexen, rx)
+	}
+	newRand := func(i int) io.Reader {
+		return mrand.New(mrand.NewSource(5 + int64(i)))
+	}
+
+	want, _ := b.generateAll(regen.New(), 1, newRand)
+	for _, jobs := range []int{2, 3, 8} {
+		got, _ := b.generateAll(regen.New(), jobs, newRand)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("generateAll with %d jobs = %q; want %q", jobs, got, want)
+		}
+	}
+}
+
+func TestWorkerKeep(t *testing.T) {
+	b := &batch{
+		ctx:      context.Background(),
+		patterns: []pattern{{expr: `[ab]+`}},
+		matchers: []*regexp.Regexp{regexp.MustCompile(`^a`)},
+		noEmpty:  true,
+		seen:     []map[string]struct{}{{}},
+	}
+	wk := &worker{batch: b, gen: regen.New()}
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", false},  // -no-empty
+		{"b", false}, // -verify
+		{"ab", true},
+		{"ab", false}, // -unique
+		{"aa", true},
+	}
+	for _, tt := range tests {
+		if got := wk.keep(0, tt.s); got != tt.want {
+			t.Errorf("keep(%q) = %t; want %t", tt.s, got, tt.want)
+		}
+	}
+	if wk.stats.strings != 2 {
+		t.Errorf("keep recorded %d strings; want 2", wk.stats.strings)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	patterns := []pattern{{expr: `(?P<x>a)&<b>`}, {expr: `c|d`}}
+	tests := []struct {
+		zip     bool
+		results [][]any
+		want    string
+	}{
+		{false, [][]any{{"a&<b>"}, {"c", "d"}}, `{"(?P<x>a)&<b>":["a&<b>"],"c|d":["c","d"]}` + "\n"},
+		{false, [][]any{{captured{Value: "a&<b>", Captures: map[string]string{"x": "<"}}}, {}},
+			`{"(?P<x>a)&<b>":[{"value":"a&<b>","captures":{"x":"<"}}],"c|d":[]}` + "\n"},
+		{true, [][]any{{"a&<b>", "c"}, {"a&<b>"}}, `[["a&<b>","c"],["a&<b>"]]` + "\n"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		if err := writeJSON(&sb, patterns, tt.results, tt.zip); err != nil {
+			t.Fatalf("writeJSON(%v) = %v", tt.results, err)
+		}
+		if got := sb.String(); got != tt.want {
+			t.Errorf("writeJSON(%v) = %s; want %s", tt.results, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// normalizeNewlines returns s with its line endings (\r\n, \n, or a lone \r) converted to the given -newline style.
+func normalizeNewlines(s, style string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	switch style {
+	case newlineCRLF:
+		return strings.ReplaceAll(s, "\n", "\r\n")
+	case newlineNone:
+		return strings.ReplaceAll(s, "\n", "")
+	}
+	return s
+}
+
+// escapeString returns s with non-printable characters replaced by Go escapes, as in strconv.Quote. Bytes that are
+// not valid UTF-8 are written as \x escapes. Printable characters, including backslashes, are left as-is.
+func escapeString(s string) string {
+	var sb strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&sb, `\x%02x`, s[0])
+		case unicode.IsPrint(r):
+			sb.WriteRune(r)
+		default:
+			q := strconv.QuoteRune(r)
+			sb.WriteString(q[1 : len(q)-1])
+		}
+		s = s[size:]
+	}
+	return sb.String()
+}
+
+// shellQuote returns s in single quotes, so that a POSIX shell reads it as a single argument with no expansions. Each
+// single quote in s ends the quoted string, is written as an escaped quote (\'), and starts a new quoted string.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// captured is a generated string along with the text of its capture groups, written in place of the string with
+// -json-captures.
+type captured struct {
+	Value    string            `json:"value"`
+	Captures map[string]string `json:"captures"`
+}
+
+// writeJSON writes results to w as JSON. If zip is true, results holds each round of generated strings and is written
+// as an array of arrays. Otherwise, results holds the strings generated for each pattern and is written as an object
+// mapping each pattern to its strings, in the order the patterns were given. Each result is either a string or, with
+// -json-captures, a captured.
+func writeJSON(w io.Writer, patterns []pattern, results [][]any, zip bool) error {
+	if zip {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(results)
+	}
+
+	// Write the object by hand, since encoding/json sorts map keys.
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range patterns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(p.label())
+		if err != nil {
+			return err
+		}
+		value, err := marshalJSON(results[i])
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// marshalJSON returns v encoded as JSON, the same as json.Marshal except that <, >, and & aren't escaped, since they're
+// common in regexps and the output isn't meant for HTML.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.spiff.io/regen"
+)
+
+// pattern is a regular expression pattern and where it was read from.
+type pattern struct {
+	expr string
+	rx   *syntax.Regexp // If set, the pattern already parsed (e.g., from a grammar), which expr is written from.
+	name string         // If set, shown in place of expr (e.g., the template a pattern was made from).
+	src  string         // Name of the input the pattern was read from, or empty if from the command line.
+	line int            // Line number of the pattern in src, or 0 if it was made from all of src (e.g., a grammar rule).
+	n    int            // Number of strings to generate.
+	hasN bool           // Whether n was given with the pattern (as pattern@n) instead of by -n.
+	// comment is the text of the # comment on the line just before the pattern in a patterns file, if any, for -label.
+	comment string
+}
+
+// lineLabel returns the label -label writes before strings generated from the pattern: its comment, if by is
+// "comment" and it has one, or else its line number, or nth (its position among the patterns) if it has none.
+func (p pattern) lineLabel(by string, nth int) string {
+	if by == labelComment && p.comment != "" {
+		return p.comment
+	} else if p.line > 0 {
+		return strconv.Itoa(p.line)
+	}
+	return strconv.Itoa(nth)
+}
+
+// label returns the name of the pattern, if it has one, or its expression.
+func (p pattern) label() string {
+	if p.name != "" {
+		return p.name
+	}
+	return p.expr
+}
+
+// String returns a description of where the pattern came from for use in error messages.
+func (p pattern) String() string {
+	if p.src == "" {
+		return fmt.Sprintf("%q", p.label())
+	} else if p.line == 0 {
+		return fmt.Sprintf("%q (%s)", p.label(), p.src)
+	}
+	return fmt.Sprintf("%q (%s:%d)", p.label(), p.src, p.line)
+}
+
+// parseArg parses a pattern given on the command line. If the pattern ends in @ followed by a count (e.g.,
+// '\d{3}-\d{4}@5'), that many strings are generated from it instead of -n. To end a pattern with a literal @ and
+// digits, escape the @ (\@).
+func parseArg(arg string) (pattern, error) {
+	i := strings.LastIndexByte(arg, '@')
+	if i == -1 || i == len(arg)-1 || strings.TrimLeft(arg[i+1:], "0123456789") != "" {
+		return pattern{expr: arg}, nil
+	}
+
+	// Count backslashes before the @ to see if it's escaped.
+	slashes := 0
+	for j := i - 1; j >= 0 && arg[j] == '\\'; j-- {
+		slashes++
+	}
+	if slashes%2 == 1 {
+		return pattern{expr: arg}, nil
+	}
+
+	n, err := strconv.Atoi(arg[i+1:])
+	if err != nil || n > maxN {
+		return pattern{}, fmt.Errorf("count %s must be between 0 and %d", arg[i+1:], maxN)
+	}
+	return pattern{expr: arg[:i], n: n, hasN: true}, nil
+}
+
+// readPatterns reads patterns, one per line, from r. Blank lines are skipped. If comments is true, lines starting
+// with # are also skipped, and a comment on the line just before a pattern is kept as its comment.
+func readPatterns(r io.Reader, src string, comments bool) ([]pattern, error) {
+	var patterns []pattern
+	sc := bufio.NewScanner(r)
+	comment := ""
+	for line := 1; sc.Scan(); line++ {
+		expr := strings.TrimSuffix(sc.Text(), "\r")
+		if comments && strings.HasPrefix(expr, "#") {
+			comment = strings.TrimSpace(expr[1:])
+			continue
+		} else if expr == "" {
+			comment = ""
+			continue
+		}
+		patterns = append(patterns, pattern{expr: expr, src: src, line: line, comment: comment})
+		comment = ""
+	}
+	return patterns, sc.Err()
+}
+
+// readPatternsFile reads patterns from the file at path, skipping blank lines and lines starting with #.
+func readPatternsFile(path string) ([]pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPatterns(f, path, true)
+}
+
+// grammarPattern reads the grammar in the file at path and returns a pattern for the named rule (or the first rule, if
+// rule is empty) with references expanded up to depth levels deep.
+func grammarPattern(path, rule string, depth int, flags syntax.Flags) (pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return pattern{}, err
+	}
+	defer f.Close()
+
+	gr, err := regen.ReadGrammar(f, flags)
+	if err != nil {
+		return pattern{}, err
+	}
+	if rule == "" {
+		rules := gr.Rules()
+		if len(rules) == 0 {
+			return pattern{}, fmt.Errorf("%s has no rules", path)
+		}
+		rule = rules[0]
+	}
+	rx, err := gr.Expand(rule, depth)
+	if err != nil {
+		return pattern{}, err
+	}
+	return pattern{expr: rx.String(), rx: rx, name: rule, src: path}, nil
+}
+
+// loadFrequencies returns the rune frequency table named by table: either english, for regen.EnglishFrequencies, or the
+// path to a file with a character and its weight on each line, separated by whitespace. Blank lines and lines starting
+// with # are skipped.
+func loadFrequencies(table string) (*regen.FrequencyTable, error) {
+	if table == "english" {
+		return regen.EnglishFrequencies, nil
+	}
+
+	f, err := os.Open(table)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	weights := make(map[rune]int)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || utf8.RuneCountInString(fields[0]) != 1 {
+			return nil, fmt.Errorf("%s:%d: expected a character and a weight", table, line)
+		}
+		w, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid weight: %v", table, line, err)
+		}
+		r, _ := utf8.DecodeRuneInString(fields[0])
+		weights[r] = w
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return regen.NewFrequencyTable(weights)
+}
+
+// parseCharset parses a char class (such as [0-9a-f] or \d) or a single character and returns its rune ranges.
+func parseCharset(charset string, flags syntax.Flags) ([]rune, error) {
+	rx, err := syntax.Parse(charset, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rx.Op == syntax.OpCharClass:
+		return rx.Rune, nil
+	case rx.Op == syntax.OpLiteral && len(rx.Rune) == 1:
+		return []rune{rx.Rune[0], rx.Rune[0]}, nil
+	default:
+		return nil, fmt.Errorf("%q is not a char class", charset)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sources of randomness for -rand-source.
+const (
+	sourceCrypto  = "crypto"
+	sourceMath    = "math"
+	sourceURandom = "urandom"
+)
+
+// lockedReader is a buffered reader that's safe for concurrent use, so that a single source of randomness can be
+// shared between jobs.
+type lockedReader struct {
+	mu sync.Mutex
+	r  *bufio.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// openURandom returns a reader of /dev/urandom for -rand-source urandom.
+func openURandom() (io.Reader, error) {
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		return nil, err
+	}
+	return &lockedReader{r: bufio.NewReader(f)}, nil
+}
+
+// randomSeed returns a seed for math/rand read from crypto/rand, for -rand-source math without -seed.
+func randomSeed() (int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"strings"
+)
+
+// dumpTree writes the op tree of rx to w, one op per line, indenting sub-expressions beneath their parent.
+func dumpTree(w io.Writer, rx *syntax.Regexp, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch rx.Op {
+	case syntax.OpLiteral:
+		fmt.Fprintf(w, "%s%v %q\n", indent, rx.Op, string(rx.Rune))
+	case syntax.OpCharClass:
+		fmt.Fprintf(w, "%s%v %v\n", indent, rx.Op, rx)
+	case syntax.OpRepeat:
+		fmt.Fprintf(w, "%s%v {%d,%d}\n", indent, rx.Op, rx.Min, rx.Max)
+	case syntax.OpCapture:
+		fmt.Fprintf(w, "%s%v %d %q\n", indent, rx.Op, rx.Cap, rx.Name)
+	default:
+		fmt.Fprintf(w, "%s%v\n", indent, rx.Op)
+	}
+
+	for _, sub := range rx.Sub {
+		dumpTree(w, sub, depth+1)
+	}
+}
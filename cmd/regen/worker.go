@@ -0,0 +1,412 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.spiff.io/regen"
+)
+
+// batch is the set of patterns to generate strings from, along with the options that apply to all of them.
+type batch struct {
+	patterns []pattern
+	regexen  []*syntax.Regexp
+	matchers []*regexp.Regexp      // Compiled patterns, if -verify or -negate is set.
+	seen     []map[string]struct{} // Strings generated for each pattern, if -unique is set.
+
+	matchAlso  []*regexp.Regexp // Patterns every generated string must match (-match-also).
+	matchNever []*regexp.Regexp // Patterns no generated string may match (-match-never).
+	startsWith string           // Written before each string generated from the rest of its pattern (-starts-with).
+	charCounts []charCount      // Constraints on how many times characters occur in generated strings (-char-count).
+
+	// captures holds the text of the capture groups of each string generated for each pattern, in the order they were
+	// generated, if -json-captures is set. Capture groups are keyed by name, or by index if they're unnamed.
+	captures [][]map[string]string
+
+	ctx            context.Context // Cancelled once -timeout has passed.
+	timeout        time.Duration
+	timeoutPartial bool
+	timedOut       atomic.Bool // Set once -timeout has cut generation short with -timeout-partial set.
+
+	negate         bool
+	verifyAttempts int
+	verifyFail     string
+	uniqueAttempts int
+	noEmpty        bool
+	emptyAttempts  int
+}
+
+// worker generates strings from a batch's patterns. Each worker has its own Generator and buffer, so separate workers
+// may generate strings concurrently as long as they don't generate strings for the same pattern.
+type worker struct {
+	*batch
+	gen   *regen.Generator
+	buf   bytes.Buffer
+	stats stats
+}
+
+// stats counts what workers generated, for -report.
+type stats struct {
+	strings    int // Strings generated.
+	bytes      int // Total length of the strings generated.
+	minLen     int // Length of the shortest string generated.
+	maxLen     int // Length of the longest string generated.
+	retries    int // Strings regenerated because they failed -verify (or matched, with -negate).
+	duplicates int // Strings regenerated because they were duplicates, with -unique.
+	empties    int // Strings regenerated because they were empty, with -no-empty.
+}
+
+// add records a generated string of length n.
+func (s *stats) add(n int) {
+	if s.strings == 0 || n < s.minLen {
+		s.minLen = n
+	}
+	s.maxLen = max(s.maxLen, n)
+	s.strings++
+	s.bytes += n
+}
+
+// merge adds the counts in o to s.
+func (s *stats) merge(o stats) {
+	if o.strings > 0 && (s.strings == 0 || o.minLen < s.minLen) {
+		s.minLen = o.minLen
+	}
+	s.maxLen = max(s.maxLen, o.maxLen)
+	s.strings += o.strings
+	s.bytes += o.bytes
+	s.retries += o.retries
+	s.duplicates += o.duplicates
+	s.empties += o.empties
+}
+
+// write writes a summary of s to w.
+func (s *stats) write(w io.Writer) {
+	mean := 0.0
+	if s.strings > 0 {
+		mean = float64(s.bytes) / float64(s.strings)
+	}
+	fmt.Fprintf(w, "strings: %d\n", s.strings)
+	fmt.Fprintf(w, "bytes: %d\n", s.bytes)
+	fmt.Fprintf(w, "length: min %d, max %d, mean %.2f\n", s.minLen, s.maxLen, mean)
+	fmt.Fprintf(w, "verify retries: %d\n", s.retries)
+	fmt.Fprintf(w, "unique duplicates: %d\n", s.duplicates)
+	fmt.Fprintf(w, "empty retries: %d\n", s.empties)
+}
+
+// next generates the next string for the i-th pattern. It returns ok = false if the string was skipped because it
+// failed -verify, and done = true if -unique is set and no new unique string could be generated for the pattern, or if
+// -timeout has passed with -timeout-partial set. With -no-empty, empty strings are regenerated, and it exits with an
+// error if every attempt is empty.
+func (wk *worker) next(i int) (s string, ok, done bool) {
+	dups, empties := 0, 0
+	for {
+		if wk.expired() {
+			return "", false, true
+		} else if !wk.generate(i) {
+			return "", false, false
+		} else if wk.noEmpty && wk.buf.Len() == 0 {
+			wk.stats.empties++
+			if empties++; empties >= wk.emptyAttempts {
+				log.Printf("only generated empty strings for %v after %d attempts", wk.patterns[i], empties)
+				os.Exit(1)
+			}
+			continue
+		}
+
+		s = wk.buf.String()
+		wk.crossCheck(i, s)
+		if wk.seen == nil {
+			wk.stats.add(len(s))
+			wk.recordCaptures(i)
+			return s, true, false
+		} else if _, dup := wk.seen[i][s]; !dup {
+			wk.seen[i][s] = struct{}{}
+			wk.stats.add(len(s))
+			wk.recordCaptures(i)
+			return s, true, false
+		}
+
+		wk.stats.duplicates++
+		if dups >= wk.uniqueAttempts {
+			log.Printf("only generated %d unique strings for %v", len(wk.seen[i]), wk.patterns[i])
+			return "", false, true
+		}
+		dups++
+	}
+}
+
+// expired returns whether -timeout has passed, which stops generation. If it has and -timeout-partial isn't set, it
+// exits with an error instead.
+func (b *batch) expired() bool {
+	if b.ctx.Err() == nil {
+		return false
+	} else if !b.timeoutPartial {
+		log.Printf("timed out after %v", b.timeout)
+		os.Exit(1)
+	}
+	b.timedOut.Store(true)
+	return true
+}
+
+// stopped returns whether err, returned while generating, is from -timeout passing. If it is and -timeout-partial
+// isn't set, it exits with an error instead.
+func (b *batch) stopped(err error) bool {
+	return err != nil && b.ctx.Err() != nil && b.expired()
+}
+
+// generate generates a string for the i-th pattern into the worker's buffer, retrying with -verify until it matches.
+// With -negate, the string is mutated and retried until it doesn't match instead. Strings that don't satisfy every
+// -char-count constraint are retried the same way. It returns false if the string should be skipped.
+func (wk *worker) generate(i int) bool {
+	for attempt := 1; ; attempt++ {
+		wk.buf.Reset()
+		wk.buf.WriteString(wk.startsWith)
+		err := wk.gen.GenStringContext(wk.ctx, &wk.buf, wk.regexen[i])
+		if err != nil && wk.ctx.Err() != nil {
+			return false // Checked by next.
+		} else if err != nil {
+			log.Printf("Error generating string: %v", errText(err))
+			os.Exit(1)
+		}
+
+		if wk.negate {
+			wk.mutate()
+		}
+
+		unsatisfied := wk.unsatisfied(wk.buf.String())
+		if (wk.matchers == nil || wk.matchers[i].Match(wk.buf.Bytes()) != wk.negate) && unsatisfied == nil {
+			return true
+		} else if attempt < wk.verifyAttempts {
+			wk.stats.retries++
+			continue
+		}
+
+		if unsatisfied != nil {
+			log.Printf("generated string %q for %v does not satisfy -char-count %v after %d attempts",
+				wk.buf.String(), wk.patterns[i], unsatisfied, attempt)
+		} else if wk.negate {
+			log.Printf("mutated string %q still matches %v after %d attempts", wk.buf.String(), wk.patterns[i], attempt)
+		} else {
+			log.Printf("generated string %q does not match %v after %d attempts", wk.buf.String(), wk.patterns[i], attempt)
+		}
+		switch wk.verifyFail {
+		case failWarn:
+			return true
+		case failSkip:
+			return false
+		default:
+			os.Exit(1)
+		}
+	}
+}
+
+// keep returns whether s, a string produced for the i-th pattern some other way than generate (e.g., enumerated with
+// -all), passes the same checks as generated strings and records it in the worker's stats if so. Since s can't be
+// generated again, strings that are empty with -no-empty, fail -verify, don't satisfy -char-count, or are duplicates
+// with -unique are skipped, except that -verify-fail warn keeps strings that fail -verify or -char-count with a
+// warning. As with generated strings, it exits with an error if s fails -match-also or -match-never.
+func (wk *worker) keep(i int, s string) bool {
+	if wk.noEmpty && s == "" {
+		wk.stats.empties++
+		return false
+	}
+
+	if unsatisfied := wk.unsatisfied(s); unsatisfied != nil {
+		if wk.verifyFail != failWarn {
+			return false
+		}
+		log.Printf("string %q for %v does not satisfy -char-count %v", s, wk.patterns[i], unsatisfied)
+	} else if wk.matchers != nil && !wk.matchers[i].MatchString(s) {
+		if wk.verifyFail != failWarn {
+			return false
+		}
+		log.Printf("string %q does not match %v", s, wk.patterns[i])
+	}
+
+	if wk.seen != nil {
+		if _, dup := wk.seen[i][s]; dup {
+			wk.stats.duplicates++
+			return false
+		}
+		wk.seen[i][s] = struct{}{}
+	}
+	wk.crossCheck(i, s)
+	wk.stats.add(len(s))
+	return true
+}
+
+// recordCaptures appends the text of the capture groups of the string just generated for the i-th pattern to its
+// captures, if -json-captures is set.
+func (wk *worker) recordCaptures(i int) {
+	if wk.captures == nil {
+		return
+	}
+	names := wk.regexen[i].CapNames()
+	caps := make(map[string]string)
+	for idx, text := range wk.gen.Captures() {
+		if idx < len(names) && names[idx] != "" {
+			caps[names[idx]] = text
+		} else {
+			caps[strconv.Itoa(idx)] = text
+		}
+	}
+	wk.captures[i] = append(wk.captures[i], caps)
+}
+
+// unsatisfied returns the first -char-count constraint that s doesn't satisfy, or nil if it satisfies all of them.
+func (wk *worker) unsatisfied(s string) *charCount {
+	for i, c := range wk.charCounts {
+		if !c.ok(s) {
+			return &wk.charCounts[i]
+		}
+	}
+	return nil
+}
+
+// crossCheck exits with an error if s, generated for the i-th pattern, doesn't match a -match-also pattern or matches
+// a -match-never pattern.
+func (wk *worker) crossCheck(i int, s string) {
+	for _, re := range wk.matchAlso {
+		if !re.MatchString(s) {
+			log.Printf("generated string %q for %v does not match -match-also %q", s, wk.patterns[i], re)
+			os.Exit(1)
+		}
+	}
+	for _, re := range wk.matchNever {
+		if re.MatchString(s) {
+			log.Printf("generated string %q for %v matches -match-never %q", s, wk.patterns[i], re)
+			os.Exit(1)
+		}
+	}
+}
+
+// mutate changes a random character in the worker's buffer by replacing or deleting it, or inserts a new character,
+// for -negate. Inserted and replacement characters are printable ASCII.
+func (wk *worker) mutate() {
+	rs := []rune(wk.buf.String())
+	pos := wk.randint(len(rs) + 1)
+	op := wk.randint(3)
+	if pos == len(rs) {
+		op = 0 // Only insertion is possible at the end of the string.
+	}
+
+	c := rune(' ' + wk.randint(95))
+	switch op {
+	case 0:
+		rs = append(rs[:pos], append([]rune{c}, rs[pos:]...)...)
+	case 1:
+		if c == rs[pos] {
+			c = ' ' + (c-' '+1)%95
+		}
+		rs[pos] = c
+	case 2:
+		rs = append(rs[:pos], rs[pos+1:]...)
+	}
+
+	wk.buf.Reset()
+	wk.buf.WriteString(string(rs))
+}
+
+// randint returns a random integer in the range [0, max) read from the worker Generator's source of randomness.
+func (wk *worker) randint(max int) int {
+	r := wk.gen.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	n, err := rand.Int(r, big.NewInt(int64(max)))
+	if err != nil {
+		log.Printf("Error reading random number: %v", err)
+		os.Exit(1)
+	}
+	return int(n.Int64())
+}
+
+// stream writes strings generated from each of the batch's patterns in turn to w, each passed through format (with the
+// index of its pattern) and followed by sep, until writing fails or, with -unique, no pattern can generate a new
+// string. Each string is flushed as soon as it's written.
+func (wk *worker) stream(w *bufio.Writer, format func(int, string) string, sep string) error {
+	exhausted := make([]bool, len(wk.regexen))
+	for live := len(wk.regexen); live > 0; {
+		for i := range wk.regexen {
+			if exhausted[i] {
+				continue
+			}
+			s, ok, done := wk.next(i)
+			if done {
+				exhausted[i] = true
+				live--
+				continue
+			} else if !ok {
+				continue
+			}
+
+			w.WriteString(format(i, s))
+			w.WriteString(sep)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generateAll generates up to the count of strings for each of the batch's patterns using the given number of
+// concurrent jobs and returns them, in order, for each pattern, along with the combined stats of each job. Each job
+// uses a clone of gen. If newRand is not nil, it is called to get the source of randomness for each pattern, so that
+// the strings generated don't depend on which job generated them.
+func (b *batch) generateAll(gen *regen.Generator, jobs int, newRand func(i int) io.Reader) ([][]string, stats) {
+	results := make([][]string, len(b.regexen))
+	indices := make(chan int)
+	workers := make([]*worker, jobs)
+
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wk := &worker{batch: b, gen: gen.Clone()}
+		workers[j] = wk
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if newRand != nil {
+					wk.gen.Rand = newRand(i)
+				}
+				for k := 0; k < b.patterns[i].n; k++ {
+					s, ok, done := wk.next(i)
+					if done {
+						break
+					} else if ok {
+						results[i] = append(results[i], s)
+					}
+				}
+			}
+		}()
+	}
+
+	for i := range b.regexen {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var st stats
+	for _, wk := range workers {
+		st.merge(wk.stats)
+	}
+	return results, st
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"regexp"
+	"regexp/syntax"
+)
+
+// ParseRegexp parses the source of a compiled regexp, as returned by its String method, so that strings can be
+// generated from it.
+//
+// A *regexp.Regexp doesn't record the syntax flags it was compiled with, so its source is always parsed with
+// syntax.Perl, which is what regexp.Compile and regexp.MustCompile use. Flags set inside of the pattern itself (such as
+// (?i) or (?s)) are part of its source and are kept. Regexps compiled with regexp.CompilePOSIX are parsed as if they
+// were Perl syntax, so Perl escapes like \d are recognized and ^ and $ only match at the beginning and end of text,
+// where POSIX syntax would also let them match at line boundaries. Calling Longest on a regexp has no effect on what is
+// generated.
+func ParseRegexp(re *regexp.Regexp) (*syntax.Regexp, error) {
+	return Parse(re.String(), syntax.Perl)
+}
+
+// GenerateRegexp returns a string generated from the compiled regexp re. See ParseRegexp for how re is parsed. To
+// generate many strings from the same regexp, parse it once with ParseRegexp and pass the result to Generate instead.
+func (g *Generator) GenerateRegexp(re *regexp.Regexp) (string, error) {
+	rx, err := ParseRegexp(re)
+	if err != nil {
+		return "", err
+	}
+	return g.Generate(rx)
+}
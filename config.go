@@ -0,0 +1,78 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+// GenConfig holds the options that control the shape of the strings a Generator generates, so that they can be set in
+// one place. Each field is the same as the Generator field of the same name. Start from DefaultGenConfig, which holds
+// the options New uses, since the zero GenConfig never repeats unbounded repetitions or includes optional parts.
+type GenConfig struct {
+	UnboundMax   int
+	UnboundMin   int
+	MaxRepeat    int
+	RepeatBudget int
+	Dist         Distribution
+	QuestProb    float64
+	NewlineProb  float64
+	NewlineRune  rune
+	DotRanges    []rune
+	Unicode      bool
+	ASCII        bool
+	Bytes        bool
+	MinLen       int
+	MaxLen       int
+	CountRunes   bool
+}
+
+// DefaultGenConfig returns the GenConfig of a Generator returned by New.
+func DefaultGenConfig() GenConfig {
+	return New().Config()
+}
+
+// NewWithConfig allocates a new Generator with the options in c, using crypto/rand as its source of randomness. Other
+// options are the same as for New.
+func NewWithConfig(c GenConfig) *Generator {
+	g := New()
+	g.SetConfig(c)
+	return g
+}
+
+// Config returns the Generator's options that are held by a GenConfig.
+func (g *Generator) Config() GenConfig {
+	return GenConfig{
+		UnboundMax:   g.UnboundMax,
+		UnboundMin:   g.UnboundMin,
+		MaxRepeat:    g.MaxRepeat,
+		RepeatBudget: g.RepeatBudget,
+		Dist:         g.Dist,
+		QuestProb:    g.QuestProb,
+		NewlineProb:  g.NewlineProb,
+		NewlineRune:  g.NewlineRune,
+		DotRanges:    g.DotRanges,
+		Unicode:      g.Unicode,
+		ASCII:        g.ASCII,
+		Bytes:        g.Bytes,
+		MinLen:       g.MinLen,
+		MaxLen:       g.MaxLen,
+		CountRunes:   g.CountRunes,
+	}
+}
+
+// SetConfig sets the Generator's options to those in c. Options that aren't held by a GenConfig are left as-is.
+func (g *Generator) SetConfig(c GenConfig) {
+	g.UnboundMax = c.UnboundMax
+	g.UnboundMin = c.UnboundMin
+	g.MaxRepeat = c.MaxRepeat
+	g.RepeatBudget = c.RepeatBudget
+	g.Dist = c.Dist
+	g.QuestProb = c.QuestProb
+	g.NewlineProb = c.NewlineProb
+	g.NewlineRune = c.NewlineRune
+	g.DotRanges = c.DotRanges
+	g.Unicode = c.Unicode
+	g.ASCII = c.ASCII
+	g.Bytes = c.Bytes
+	g.MinLen = c.MinLen
+	g.MaxLen = c.MaxLen
+	g.CountRunes = c.CountRunes
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"math/big"
+	"regexp/syntax"
+)
+
+// Count returns the number of strings the Generator could produce from rx, counting unbounded repetitions up to
+// UnboundMax. If rx contains an unbounded repetition, infinite is true and n is the count with that limit applied.
+//
+// Count walks the same op tree as GenString, so it counts the distinct ways a string can be generated rather than
+// distinct strings: ambiguous patterns, such as a|a or a*a*, are counted more than once. If rx is nested deeper than
+// MaxDepth, n is zero.
+func (g *Generator) Count(rx *syntax.Regexp) (n *big.Int, infinite bool) {
+	if g.CheckDepth(rx) != nil {
+		return new(big.Int), false
+	}
+	return g.count(rx)
+}
+
+func (g *Generator) count(rx *syntax.Regexp) (n *big.Int, infinite bool) {
+	n = new(big.Int)
+	switch rx.Op {
+	case syntax.OpNoMatch:
+	case syntax.OpLiteral:
+		n.SetInt64(1)
+		if rx.Flags&syntax.FoldCase != 0 {
+			for _, r := range rx.Rune {
+				n.Mul(n, big.NewInt(int64(len(g.foldRunes(r)))))
+			}
+		}
+	case syntax.OpEmptyMatch,
+		syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		n.SetInt64(1)
+	case syntax.OpCharClass:
+		ranges := rx.Rune
+		if validRanges(ranges) {
+			ranges = intersectRanges(normalizeRanges(ranges), unicodeRanges)
+		}
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		n.SetInt64(rangesLen(ranges))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		n.SetInt64(rangesLen(g.anyRanges(rx.Op)))
+	case syntax.OpConcat, syntax.OpCapture:
+		n.SetInt64(1)
+		for _, sub := range rx.Sub {
+			sn, sinf := g.count(sub)
+			n.Mul(n, sn)
+			infinite = infinite || sinf
+		}
+	case syntax.OpAlternate:
+		for _, sub := range rx.Sub {
+			sn, sinf := g.count(sub)
+			n.Add(n, sn)
+			infinite = infinite || sinf
+		}
+	case syntax.OpQuest, syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		min, max := 0, 1
+		if rx.Op != syntax.OpQuest {
+			min, max = g.repeatBounds(rx)
+		}
+		infinite = rx.Op == syntax.OpStar || rx.Op == syntax.OpPlus || (rx.Op == syntax.OpRepeat && rx.Max == -1)
+
+		// Count a single repetition of the sub-expressions, then sum its powers over the range of repetitions.
+		one := big.NewInt(1)
+		for _, sub := range rx.Sub {
+			sn, sinf := g.count(sub)
+			one.Mul(one, sn)
+			infinite = infinite || sinf
+		}
+		switch one.Sign() {
+		case 0:
+			// Only zero repetitions can be generated, if allowed.
+			if min == 0 {
+				n.SetInt64(1)
+			}
+		default:
+			if one.IsInt64() && one.Int64() == 1 {
+				n.SetInt64(int64(max - min + 1))
+				break
+			}
+			// Sum the geometric series one**min + ... + one**max = (one**(max+1) - one**min) / (one - 1).
+			lo := new(big.Int).Exp(one, big.NewInt(int64(min)), nil)
+			n.Exp(one, big.NewInt(int64(max)+1), nil)
+			n.Sub(n, lo)
+			n.Quo(n, lo.Sub(one, big.NewInt(1)))
+		}
+	}
+	return n, infinite
+}
+
+// rangesLen returns the number of runes in a set of rune ranges (as used by OpCharClass).
+func rangesLen(ranges []rune) int64 {
+	sum := int64(0)
+	for i := 0; i < len(ranges); i += 2 {
+		sum += 1 + int64(ranges[i+1]-ranges[i])
+	}
+	return sum
+}
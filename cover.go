@@ -0,0 +1,117 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"bytes"
+	"context"
+	"regexp/syntax"
+)
+
+// coverAttempts is the number of strings in a row that CoverAlternates may generate without covering a new branch
+// before it gives up on the branches left.
+const coverAttempts = 100
+
+// branch is a sub-expression of an OpAlternate, identified by its index.
+type branch struct {
+	rx  *syntax.Regexp
+	nth int
+}
+
+// CoverAlternates returns strings generated from rx that together take every branch of every alternation in rx at
+// least once. Each string is only kept if it takes a branch that no string before it did, and each alternation takes
+// a branch that hasn't been taken yet, or that leads to one, whenever it can, so the strings returned are few but not
+// necessarily the fewest possible. A regexp without alternations gets a single string.
+//
+// Branches are only reached as other choices allow, so a branch inside an optional part or a repetition may take a few
+// tries. Branches still not taken after a number of strings in a row cover nothing new, such as those that can only be
+// reached through a{0} or that can't match anything, are left out. Other options, such as MinLen and MaxLen, apply as
+// usual, and Trace and Replay only see the random choices made, not the branches picked to cover new ones.
+func (g *Generator) CoverAlternates(rx *syntax.Regexp) ([]string, error) {
+	return g.CoverAlternatesContext(context.Background(), rx)
+}
+
+// CoverAlternatesContext is the same as CoverAlternates, except that it stops generating and returns the strings kept
+// so far along with ctx's error if ctx is cancelled. The context is checked before each string is generated, as well
+// as while generating it (see GenStringContext).
+func (g *Generator) CoverAlternatesContext(ctx context.Context, rx *syntax.Regexp) ([]string, error) {
+	tree := rx
+	if g.Simplify {
+		// Branches are tracked in the regexp that's generated from, which run takes from the same cache.
+		tree = g.simplify(rx)
+	}
+
+	g.covered = make(map[*syntax.Regexp][]bool)
+	defer func() { g.covered, g.taken = nil, nil }()
+
+	var out []string
+	var buf bytes.Buffer
+	for stale := 0; stale < coverAttempts; {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		buf.Reset()
+		if err := g.GenStringContext(ctx, &buf, rx); err != nil {
+			return out, err
+		}
+		s := buf.String()
+
+		fresh := false
+		for _, b := range g.taken {
+			if g.isCovered(b.rx, b.nth) {
+				continue
+			} else if g.covered[b.rx] == nil {
+				g.covered[b.rx] = make([]bool, len(b.rx.Sub))
+			}
+			g.covered[b.rx][b.nth] = true
+			fresh = true
+		}
+
+		if fresh || len(out) == 0 {
+			out = append(out, s)
+			stale = 0
+		} else {
+			stale++
+		}
+		if !g.uncovered(tree) {
+			break
+		}
+	}
+	return out, nil
+}
+
+// coverBranch returns the index of the sub-expression of the OpAlternate rx to generate to cover a branch that hasn't
+// been taken yet: the first one not taken, or else the first one leading to one not taken. ok is false if every branch
+// in and under rx has been taken.
+func (g *Generator) coverBranch(rx *syntax.Regexp) (nth int, ok bool) {
+	for i, sub := range rx.Sub {
+		if !g.dead[sub] && !g.isCovered(rx, i) {
+			return i, true
+		}
+	}
+	for i, sub := range rx.Sub {
+		if !g.dead[sub] && g.uncovered(sub) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// isCovered returns whether CoverAlternates has taken the nth sub-expression of the OpAlternate rx.
+func (g *Generator) isCovered(rx *syntax.Regexp, nth int) bool {
+	c := g.covered[rx]
+	return c != nil && c[nth]
+}
+
+// uncovered returns whether rx contains an alternation with a branch that can match something but hasn't been taken.
+func (g *Generator) uncovered(rx *syntax.Regexp) bool {
+	for i, sub := range rx.Sub {
+		if g.dead[sub] {
+			continue
+		} else if rx.Op == syntax.OpAlternate && !g.isCovered(rx, i) || g.uncovered(sub) {
+			return true
+		}
+	}
+	return false
+}
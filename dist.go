@@ -0,0 +1,143 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"fmt"
+	"math"
+	"regexp/syntax"
+)
+
+// Distribution is the distribution used to choose repetition counts for repetition ops (*, +, and {m,n}).
+type Distribution int
+
+const (
+	// Uniform makes every repetition count between the min and max equally likely.
+	Uniform Distribution = iota
+	// Geometric makes each additional repetition past the min half as likely as the one before it.
+	Geometric
+	// MaxBiased makes each repetition short of the max half as likely as the one after it, the mirror image of
+	// Geometric, so that counts are usually at or near the max.
+	MaxBiased
+)
+
+var distNames = [...]string{
+	Uniform:   "uniform",
+	Geometric: "geometric",
+	MaxBiased: "max-biased",
+}
+
+func (d Distribution) String() string {
+	if d >= 0 && int(d) < len(distNames) {
+		return distNames[d]
+	}
+	return fmt.Sprintf("Distribution(%d)", int(d))
+}
+
+// ParseDistribution returns the Distribution with the given name (e.g., "uniform" or "geometric").
+func ParseDistribution(name string) (Distribution, error) {
+	for d, dn := range distNames {
+		if dn == name {
+			return Distribution(d), nil
+		}
+	}
+	return 0, fmt.Errorf("regen: unknown distribution %q", name)
+}
+
+// repeatBounds returns the minimum and maximum number of times to repeat rx, which must be an OpStar, OpPlus, or
+// OpRepeat. Unbounded repetitions, whether written as *, +, or {m,}, repeat at least UnboundMin times and may repeat up
+// to UnboundMax times beyond their minimum so that all of them are drawn from the same range by repeat. Both bounds are
+// clamped to the range [0, MaxRepeat], and max is never less than min.
+func (g *Generator) repeatBounds(rx *syntax.Regexp) (min, max int) {
+	switch rx.Op {
+	case syntax.OpStar:
+		min, max = 0, -1
+	case syntax.OpPlus:
+		min, max = 1, -1
+	default:
+		min, max = rx.Min, rx.Max
+	}
+	if max == -1 {
+		if min < g.UnboundMin {
+			min = g.UnboundMin
+		}
+		max = satAddInt(min, g.UnboundMax)
+	}
+
+	// Clamp bounds that are out of range (from hand-built regexps or huge options) so that counts can't overflow.
+	ceiling := g.MaxRepeat
+	if ceiling <= 0 {
+		ceiling = DefaultMaxRepeat
+	}
+	min = clamp(min, 0, ceiling)
+	max = clamp(max, min, ceiling)
+	return min, max
+}
+
+// clamp returns n limited to the range [lo, hi].
+func clamp(n, lo, hi int) int {
+	return min(max(n, lo), hi)
+}
+
+// satAddInt returns a+b, or math.MaxInt if it would overflow. b may be negative, in which case a+b is returned as-is.
+func satAddInt(a, b int) int {
+	if b > 0 && a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// capRepeat returns max reduced so that repeating it within the repetitions currently being generated stays within
+// the RepeatBudget, if set. The result is never less than min.
+func (g *Generator) capRepeat(min, max int) int {
+	if g.RepeatBudget <= 0 {
+		return max
+	}
+	left := g.RepeatBudget / g.product
+	if max > left {
+		max = left
+	}
+	if max < min {
+		max = min
+	}
+	return max
+}
+
+// nest records that the sub-expressions of a repetition are about to be generated n times, for RepeatBudget, and
+// returns the previous product of repetition counts to restore once they're done.
+func (g *Generator) nest(n int) (outer int) {
+	outer = g.product
+	if n > 1 {
+		g.product = satMulInt(outer, n)
+	}
+	return outer
+}
+
+// satMulInt returns a*b, or math.MaxInt if it would overflow. Both must be non-negative.
+func satMulInt(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+// repeat returns a repetition count in the range [min, max] using the Generator's distribution.
+func (g *Generator) repeat(min, max int) int {
+	switch g.Dist {
+	case Geometric:
+		n := min
+		for n < max && g.randint(2) == 1 {
+			n++
+		}
+		return n
+	case MaxBiased:
+		n := max
+		for n > min && g.randint(2) == 1 {
+			n--
+		}
+		return n
+	default:
+		return min + int(g.randint(int64(max)-int64(min)+1))
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"errors"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// errEnumLimit stops Enumerate once it has produced its limit of strings.
+var errEnumLimit = errors.New("regen: enumeration limit reached")
+
+// Enumerate calls fn with each distinct string the Generator could produce from rx, counting unbounded repetitions up
+// to UnboundMax, until limit strings have been produced (if limit is greater than zero) or fn returns an error, which
+// is returned. Strings are produced in the order of the op tree: alternation branches in order, repetition counts from
+// least to most, and runes in char classes from lowest to highest.
+//
+// Only the ops that produce text are enumerated. Anchors and word boundaries are ignored, so strings from patterns
+// using them may not match, and MinLen, MaxLen, and RepeatBudget are not applied. Since strings are kept to skip
+// duplicates, the limit should be small enough for every string produced to fit in memory. If rx is nested deeper than
+// MaxDepth, Enumerate returns an error without calling fn.
+func (g *Generator) Enumerate(rx *syntax.Regexp, limit int, fn func(string) error) error {
+	if err := g.CheckDepth(rx); err != nil {
+		return err
+	}
+	seen := make(map[string]struct{})
+	err := g.enum(rx, nil, func(b []byte) error {
+		if _, dup := seen[string(b)]; dup {
+			return nil
+		}
+		s := string(b)
+		seen[s] = struct{}{}
+		if err := fn(s); err != nil {
+			return err
+		} else if limit > 0 && len(seen) >= limit {
+			return errEnumLimit
+		}
+		return nil
+	})
+	if err == errEnumLimit {
+		return nil
+	}
+	return err
+}
+
+// enum appends each string that rx can produce to b and passes it to k, stopping at the first error returned by k.
+// Slices passed to k are only valid until k returns.
+func (g *Generator) enum(rx *syntax.Regexp, b []byte, k func([]byte) error) error {
+	switch rx.Op {
+	case syntax.OpNoMatch:
+		return nil
+	case syntax.OpLiteral:
+		if rx.Flags&syntax.FoldCase == 0 {
+			return k(append(b, string(rx.Rune)...))
+		}
+		return g.enumFold(rx.Rune, b, k)
+	case syntax.OpCharClass:
+		ranges := rx.Rune
+		if validRanges(ranges) {
+			ranges = normalizeRanges(ranges)
+		}
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		return enumRanges(ranges, b, k)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return enumRanges(g.anyRanges(rx.Op), b, k)
+	case syntax.OpConcat, syntax.OpCapture:
+		return g.enumSeq(rx.Sub, b, k)
+	case syntax.OpAlternate:
+		for _, sub := range rx.Sub {
+			if err := g.enum(sub, b, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	case syntax.OpQuest:
+		if err := k(b); err != nil {
+			return err
+		}
+		return g.enumSeq(rx.Sub, b, k)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		min, max := g.repeatBounds(rx)
+		for n := min; n <= max; n++ {
+			if err := g.enumRepeat(rx.Sub, n, b, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return k(b)
+	}
+}
+
+// enumSeq passes each string produced by the concatenation of subs, appended to b, to k.
+func (g *Generator) enumSeq(subs []*syntax.Regexp, b []byte, k func([]byte) error) error {
+	if len(subs) == 0 {
+		return k(b)
+	}
+	return g.enum(subs[0], b, func(b []byte) error {
+		return g.enumSeq(subs[1:], b, k)
+	})
+}
+
+// enumRepeat passes each string produced by repeating the concatenation of subs n times, appended to b, to k.
+func (g *Generator) enumRepeat(subs []*syntax.Regexp, n int, b []byte, k func([]byte) error) error {
+	if n == 0 {
+		return k(b)
+	}
+	return g.enumSeq(subs, b, func(b []byte) error {
+		return g.enumRepeat(subs, n-1, b, k)
+	})
+}
+
+// enumFold passes each case of the case-insensitive literal runes, appended to b, to k.
+func (g *Generator) enumFold(runes []rune, b []byte, k func([]byte) error) error {
+	if len(runes) == 0 {
+		return k(b)
+	}
+	for _, f := range g.foldRunes(runes[0]) {
+		if err := g.enumFold(runes[1:], utf8.AppendRune(b, f), k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enumRanges passes each rune in ranges, appended to b, to k. Surrogates, which can't be encoded as UTF-8, are skipped.
+func enumRanges(ranges []rune, b []byte, k func([]byte) error) error {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1]; r++ {
+			if !utf8.ValidRune(r) {
+				continue
+			} else if err := k(utf8.AppendRune(b, r)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
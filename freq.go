@@ -0,0 +1,72 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FrequencyTable holds the relative weights of runes for the Generator to pick from char classes and . (see
+// Generator.Frequencies). A FrequencyTable is immutable once created, so it may be shared between Generators.
+type FrequencyTable struct {
+	runes   []rune // Sorted runes with a weight greater than zero.
+	weights []int  // Weights of each rune in runes.
+}
+
+// NewFrequencyTable returns a FrequencyTable with the given relative weights for each rune. Runes with a weight of zero
+// are left out of the table, and negative weights are an error.
+func NewFrequencyTable(weights map[rune]int) (*FrequencyTable, error) {
+	t := &FrequencyTable{}
+	for r, w := range weights {
+		if w < 0 {
+			return nil, fmt.Errorf("regen: rune %q has negative weight %d", r, w)
+		} else if w > 0 {
+			t.runes = append(t.runes, r)
+		}
+	}
+	sort.Slice(t.runes, func(i, j int) bool { return t.runes[i] < t.runes[j] })
+	t.weights = make([]int, len(t.runes))
+	for i, r := range t.runes {
+		t.weights[i] = weights[r]
+	}
+	return t, nil
+}
+
+// EnglishFrequencies weights lowercase letters by how often they occur in English text.
+var EnglishFrequencies, _ = NewFrequencyTable(map[rune]int{
+	'a': 82, 'b': 15, 'c': 28, 'd': 43, 'e': 127, 'f': 22, 'g': 20, 'h': 61, 'i': 70, 'j': 2, 'k': 8, 'l': 40, 'm': 24,
+	'n': 67, 'o': 75, 'p': 19, 'q': 1, 'r': 60, 's': 63, 't': 91, 'u': 28, 'v': 10, 'w': 24, 'x': 2, 'y': 20, 'z': 1,
+})
+
+// pick returns a rune from ranges chosen by weight from the table, using g for randomness. ok is false if none of the
+// runes in the table are in ranges.
+func (t *FrequencyTable) pick(g *Generator, ranges []rune) (r rune, ok bool) {
+	sum := int64(0)
+	for i, r := range t.runes {
+		if inRanges(r, ranges) {
+			sum += int64(t.weights[i])
+		}
+	}
+	if sum == 0 {
+		return 0, false
+	}
+
+	nth := g.randint(sum)
+	for i, r := range t.runes {
+		if !inRanges(r, ranges) {
+			continue
+		} else if nth < int64(t.weights[i]) {
+			return r, true
+		}
+		nth -= int64(t.weights[i])
+	}
+	return 0, false
+}
+
+// inRanges returns whether r is in the sorted rune ranges.
+func inRanges(r rune, ranges []rune) bool {
+	i := sort.Search(len(ranges)/2, func(i int) bool { return ranges[2*i+1] >= r })
+	return i < len(ranges)/2 && ranges[2*i] <= r
+}
@@ -0,0 +1,274 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// DefaultGrammarDepth is the default max depth of rule references expanded by Grammar.Expand.
+const DefaultGrammarDepth = 8
+
+// refBase is the first of the private-use runes that stand in for rule references while parsing a rule: the nth
+// reference in a rule is written as the rune refBase+n in a capture group, which keeps the parser from merging it into
+// a char class or repeating only part of it, and parses with any syntax flags. With syntax.Literal, it's written alone.
+const refBase = 0x100000
+
+// maxRefs is the max number of references in a single rule, limited by the private-use runes from refBase on.
+const maxRefs = 0xFFFE
+
+// Grammar is a set of named rules, each a pattern that may refer to other rules (or itself) by name as {name}. A rule
+// is expanded into a single regexp by replacing each reference with the rule it refers to:
+//
+//	list = {item}(, {item})*
+//	item = [a-z]+|\[{list}\]
+//
+// Since rules may refer to each other recursively, references are only expanded up to a max depth. Past it, a reference
+// can't match anything, so the Generator never chooses alternation branches or optional parts that contain it. In the
+// example above, item always generates [a-z]+ once the max depth is reached.
+//
+// References inside of char classes, escaped braces (\{), and text quoted by \Q...\E are not treated as
+// references. Capture groups inside of rules are numbered by the rule they're in, so Captures isn't meaningful for an
+// expanded rule.
+type Grammar struct {
+	names []string                  // Rule names, in the order they were defined.
+	rules map[string]*syntax.Regexp // Parsed rules, with references as refBase runes.
+	refs  map[string][]string       // Names referred to by each rule's refBase runes, by index.
+}
+
+// ParseGrammar parses rules, given as a map of rule names to patterns, using the given syntax flags. It is an error
+// for a rule to refer to a rule that isn't defined. Errors parsing a rule's pattern are returned as a *ParseError.
+func ParseGrammar(rules map[string]string, flags syntax.Flags) (*Grammar, error) {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return parseGrammar(names, rules, flags)
+}
+
+// ReadGrammar reads rules from r, one per line, as name = pattern, and parses them using the given syntax flags. The
+// pattern is everything after the first = with surrounding whitespace removed. Blank lines and lines starting with #
+// are skipped.
+func ReadGrammar(r io.Reader, flags syntax.Flags) (*Grammar, error) {
+	var names []string
+	rules := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !isRuleName(name) {
+			return nil, fmt.Errorf("regen: grammar line %d: expected name = pattern", line)
+		} else if _, dup := rules[name]; dup {
+			return nil, fmt.Errorf("regen: grammar line %d: rule %s is already defined", line, name)
+		}
+		names = append(names, name)
+		rules[name] = strings.TrimSpace(pattern)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return parseGrammar(names, rules, flags)
+}
+
+func parseGrammar(names []string, rules map[string]string, flags syntax.Flags) (*Grammar, error) {
+	gr := &Grammar{
+		names: names,
+		rules: make(map[string]*syntax.Regexp, len(rules)),
+		refs:  make(map[string][]string, len(rules)),
+	}
+	for _, name := range names {
+		expr := rules[name]
+		if flags&syntax.PerlX != 0 && flags&syntax.Literal == 0 {
+			expr = StripComments(expr)
+		}
+		expr, refs := replaceRefs(expr, flags&syntax.Literal != 0)
+		if len(refs) > maxRefs {
+			return nil, fmt.Errorf("regen: rule %s has more than %d references", name, maxRefs)
+		}
+		for _, ref := range refs {
+			if _, ok := rules[ref]; !ok {
+				return nil, fmt.Errorf("regen: rule %s refers to undefined rule %s", name, ref)
+			}
+		}
+		rx, err := syntax.Parse(expr, flags)
+		if err != nil {
+			return nil, &ParseError{Pattern: rules[name], Err: fmt.Errorf("rule %s: %w", name, err)}
+		}
+		if !refsOnce(rx, make([]bool, len(refs))) {
+			return nil, fmt.Errorf("regen: rule %s uses a rune from %U to %U, which stand for rule references", name,
+				rune(refBase), rune(refBase+maxRefs-1))
+		}
+		gr.rules[name] = rx
+		gr.refs[name] = refs
+	}
+	return gr, nil
+}
+
+// Rules returns the names of the grammar's rules, in the order they were read by ReadGrammar or sorted by name if
+// the grammar was created by ParseGrammar.
+func (gr *Grammar) Rules() []string {
+	return append([]string(nil), gr.names...)
+}
+
+// Expand returns the regexp for the named rule with references to other rules expanded up to depth levels deep. If
+// depth is less than or equal to zero, DefaultGrammarDepth is used. The same rule expanded at the same depth is shared
+// between each of its references, so the result must not be modified.
+func (gr *Grammar) Expand(name string, depth int) (*syntax.Regexp, error) {
+	if _, ok := gr.rules[name]; !ok {
+		return nil, fmt.Errorf("regen: undefined rule %s", name)
+	}
+	if depth <= 0 {
+		depth = DefaultGrammarDepth
+	}
+	e := &expander{gr: gr, memo: make(map[expansion]*syntax.Regexp)}
+	return e.rule(name, depth), nil
+}
+
+// expansion identifies a rule expanded with a given depth left.
+type expansion struct {
+	name  string
+	depth int
+}
+
+// expander expands references to rules in a grammar, memoizing each rule expanded at each depth.
+type expander struct {
+	gr   *Grammar
+	memo map[expansion]*syntax.Regexp
+}
+
+// rule returns the named rule expanded with depth levels of references left, or an OpNoMatch once depth runs out.
+func (e *expander) rule(name string, depth int) *syntax.Regexp {
+	if depth < 0 {
+		return &syntax.Regexp{Op: syntax.OpNoMatch}
+	}
+	key := expansion{name, depth}
+	if rx, ok := e.memo[key]; ok {
+		return rx
+	}
+	rx := e.subst(e.gr.rules[name], e.gr.refs[name], depth)
+	e.memo[key] = rx
+	return rx
+}
+
+// subst returns a copy of rx with its references, named by refs, replaced by their rules expanded with one less level
+// of depth.
+func (e *expander) subst(rx *syntax.Regexp, refs []string, depth int) *syntax.Regexp {
+	if rx.Op == syntax.OpCapture && rx.Sub[0].Op == syntax.OpLiteral && len(rx.Sub[0].Rune) == 1 &&
+		isRef(rx.Sub[0].Rune[0], len(refs)) {
+		return e.rule(refs[rx.Sub[0].Rune[0]-refBase], depth-1)
+	} else if rx.Op == syntax.OpLiteral {
+		return e.substLiteral(rx, refs, depth)
+	} else if len(rx.Sub) == 0 {
+		return rx
+	}
+
+	c := *rx
+	c.Sub = make([]*syntax.Regexp, len(rx.Sub))
+	for i, sub := range rx.Sub {
+		c.Sub[i] = e.subst(sub, refs, depth)
+	}
+	return &c
+}
+
+// substLiteral returns the OpLiteral rx with the references in it replaced the same as subst. With syntax.Literal,
+// references aren't in capture groups, so they may be in the middle of a literal, which is split around them.
+func (e *expander) substLiteral(rx *syntax.Regexp, refs []string, depth int) *syntax.Regexp {
+	var subs []*syntax.Regexp
+	start := 0
+	for i, r := range rx.Rune {
+		if !isRef(r, len(refs)) {
+			continue
+		}
+		if start < i {
+			subs = append(subs, &syntax.Regexp{Op: syntax.OpLiteral, Flags: rx.Flags, Rune: rx.Rune[start:i]})
+		}
+		subs = append(subs, e.rule(refs[r-refBase], depth-1))
+		start = i + 1
+	}
+	switch {
+	case subs == nil:
+		return rx
+	case start < len(rx.Rune):
+		subs = append(subs, &syntax.Regexp{Op: syntax.OpLiteral, Flags: rx.Flags, Rune: rx.Rune[start:]})
+	case len(subs) == 1:
+		return subs[0]
+	}
+	return &syntax.Regexp{Op: syntax.OpConcat, Flags: rx.Flags, Sub: subs}
+}
+
+// isRef returns whether r stands for one of the n references in a rule.
+func isRef(r rune, n int) bool {
+	return r >= refBase && r < refBase+rune(n)
+}
+
+// refsOnce returns whether each reference marked in seen appears in a literal in rx at most once and no other rune
+// reserved for references does, which would be the case if the rule used one of them itself.
+func refsOnce(rx *syntax.Regexp, seen []bool) bool {
+	if rx.Op == syntax.OpLiteral {
+		for _, r := range rx.Rune {
+			if r < refBase || r >= refBase+maxRefs {
+				continue
+			} else if !isRef(r, len(seen)) || seen[r-refBase] {
+				return false
+			}
+			seen[r-refBase] = true
+		}
+	}
+	for _, sub := range rx.Sub {
+		if !refsOnce(sub, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceRefs returns expr with each {name} reference replaced by the rune refBase plus the index of the reference,
+// in a capture group unless literal is set, along with the names referred to.
+func replaceRefs(expr string, literal bool) (string, []string) {
+	var refs []string
+	expr = rewritePattern(expr, func(sb *strings.Builder, rest string) int {
+		if rest[0] != '{' {
+			return 0
+		}
+		end := strings.IndexByte(rest, '}')
+		if end <= 0 || !isRuleName(rest[1:end]) {
+			return 0
+		}
+		if literal {
+			sb.WriteRune(refBase + rune(len(refs)))
+		} else {
+			fmt.Fprintf(sb, "(%c)", refBase+rune(len(refs)))
+		}
+		refs = append(refs, rest[1:end])
+		return end + 1
+	})
+	return expr, refs
+}
+
+// isRuleName returns whether name is a valid rule name: a letter or underscore followed by letters, digits, and
+// underscores.
+func isRuleName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
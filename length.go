@@ -0,0 +1,281 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"math"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lengths returns the min and max lengths in bytes of strings the Generator could produce from rx, counting unbounded
+// repetitions up to UnboundMax. Lengths are computed from the op tree alone, so they don't account for text discarded
+// by anchors or for MinLen and MaxLen. The max length saturates at math.MaxInt64. If rx is nested deeper than MaxDepth,
+// both are zero.
+func (g *Generator) Lengths(rx *syntax.Regexp) (min, max int64) {
+	if g.CheckDepth(rx) != nil {
+		return 0, 0
+	}
+	return g.minLength(rx), g.maxLength(rx)
+}
+
+// EstimateLength returns the expected (mean) length in bytes of strings the Generator produces from rx, given its Dist,
+// UnboundMax, QuestProb, and Weights. Like Lengths, it's computed from the op tree alone, so it doesn't account for
+// MinLen, MaxLen, RepeatBudget, Frequencies, or newlines written for line anchors, and assumes every rune in a char
+// class is equally likely.
+func (g *Generator) EstimateLength(rx *syntax.Regexp) float64 {
+	switch rx.Op {
+	case syntax.OpLiteral:
+		if rx.Flags&syntax.FoldCase == 0 {
+			return float64(len(string(rx.Rune)))
+		}
+		sum := 0.0
+		for _, r := range rx.Rune {
+			folds := g.foldRunes(r)
+			n := 0
+			for _, f := range folds {
+				n += utf8.RuneLen(f)
+			}
+			sum += float64(n) / float64(len(folds))
+		}
+		return sum
+	case syntax.OpCharClass:
+		ranges := intersectRanges(normalizeRanges(rx.Rune), unicodeRanges)
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		return rangesMeanLen(ranges)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return rangesMeanLen(g.anyRanges(rx.Op))
+	case syntax.OpConcat, syntax.OpCapture:
+		sum := 0.0
+		for _, sub := range rx.Sub {
+			sum += g.EstimateLength(sub)
+		}
+		return sum
+	case syntax.OpAlternate:
+		return g.alternateMeanLen(rx)
+	case syntax.OpQuest:
+		sum := 0.0
+		for _, sub := range rx.Sub {
+			sum += g.EstimateLength(sub)
+		}
+		return g.QuestProb * sum
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		min, max := g.repeatBounds(rx)
+		sum := 0.0
+		for _, sub := range rx.Sub {
+			sum += g.EstimateLength(sub)
+		}
+		return g.meanRepeat(min, max) * sum
+	default:
+		return 0
+	}
+}
+
+// alternateMeanLen returns the expected length in bytes of the alternation rx, weighting each sub-expression by
+// Weights, if it has valid weights, or equally otherwise.
+func (g *Generator) alternateMeanLen(rx *syntax.Regexp) float64 {
+	if len(rx.Sub) == 0 {
+		return 0
+	}
+	weights := g.Weights[rx]
+	total := 0
+	for _, w := range weights {
+		if w < 0 {
+			total = 0
+			break
+		}
+		total += w
+	}
+	if len(weights) != len(rx.Sub) || total <= 0 {
+		weights, total = nil, len(rx.Sub)
+	}
+
+	sum := 0.0
+	for i, sub := range rx.Sub {
+		w := 1
+		if weights != nil {
+			w = weights[i]
+		}
+		if w > 0 {
+			sum += float64(w) * g.EstimateLength(sub)
+		}
+	}
+	return sum / float64(total)
+}
+
+// meanRepeat returns the expected repetition count chosen by repeat for the range [min, max].
+func (g *Generator) meanRepeat(min, max int) float64 {
+	if g.Dist == Geometric {
+		// Each repetition past the min is taken with probability 1/2, so the count past the min is the sum of
+		// 1/2^k for k in [1, max-min].
+		return float64(min) + 1 - math.Pow(2, -float64(max-min))
+	} else if g.Dist == MaxBiased {
+		return float64(max) - 1 + math.Pow(2, -float64(max-min))
+	}
+	return (float64(min) + float64(max)) / 2
+}
+
+// minLength returns the min length in bytes of a string the Generator could produce from rx.
+func (g *Generator) minLength(rx *syntax.Regexp) int64 {
+	switch rx.Op {
+	case syntax.OpLiteral:
+		return g.literalLen(rx, false)
+	case syntax.OpCharClass:
+		ranges := rx.Rune
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		return rangesMinLen(ranges)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return rangesMinLen(g.anyRanges(rx.Op))
+	case syntax.OpConcat, syntax.OpCapture:
+		sum := int64(0)
+		for _, sub := range rx.Sub {
+			sum = satAdd(sum, g.minLength(sub))
+		}
+		return sum
+	case syntax.OpAlternate:
+		shortest := int64(math.MaxInt64)
+		for _, sub := range rx.Sub {
+			shortest = min(shortest, g.minLength(sub))
+		}
+		return shortest
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		count, _ := g.repeatBounds(rx)
+		one := int64(0)
+		for _, sub := range rx.Sub {
+			one = satAdd(one, g.minLength(sub))
+		}
+		return satMul(one, int64(count))
+	default:
+		return 0
+	}
+}
+
+// maxLength returns the max length in bytes of a string the Generator could produce from rx, counting unbounded
+// repetitions up to UnboundMax. The result saturates at math.MaxInt64.
+func (g *Generator) maxLength(rx *syntax.Regexp) int64 {
+	switch rx.Op {
+	case syntax.OpLiteral:
+		return g.literalLen(rx, true)
+	case syntax.OpCharClass:
+		ranges := rx.Rune
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		return rangesMaxLen(ranges)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return rangesMaxLen(g.anyRanges(rx.Op))
+	case syntax.OpBeginLine, syntax.OpEndLine:
+		return 1
+	case syntax.OpConcat, syntax.OpCapture:
+		sum := int64(0)
+		for _, sub := range rx.Sub {
+			sum = satAdd(sum, g.maxLength(sub))
+		}
+		return sum
+	case syntax.OpAlternate:
+		longest := int64(0)
+		for _, sub := range rx.Sub {
+			longest = max(longest, g.maxLength(sub))
+		}
+		return longest
+	case syntax.OpQuest, syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		count := 1
+		if rx.Op != syntax.OpQuest {
+			_, count = g.repeatBounds(rx)
+		}
+		one := int64(0)
+		for _, sub := range rx.Sub {
+			one = satAdd(one, g.maxLength(sub))
+		}
+		return satMul(one, int64(count))
+	default:
+		return 0
+	}
+}
+
+// literalLen returns the length in bytes of the literal rx. If rx is case-insensitive, the length is of the longest
+// case of each rune if longest is true, or the shortest otherwise.
+func (g *Generator) literalLen(rx *syntax.Regexp, longest bool) int64 {
+	if rx.Flags&syntax.FoldCase == 0 {
+		return int64(len(string(rx.Rune)))
+	}
+	sum := int64(0)
+	for _, r := range rx.Rune {
+		n := utf8.RuneLen(r)
+		for _, f := range g.foldRunes(r) {
+			if longest {
+				n = max(n, utf8.RuneLen(f))
+			} else {
+				n = min(n, utf8.RuneLen(f))
+			}
+		}
+		sum += int64(n)
+	}
+	return sum
+}
+
+// rangesMaxLen returns the max length in bytes of a UTF-8 encoded rune in a set of rune ranges.
+func rangesMaxLen(ranges []rune) int64 {
+	longest := 0
+	for i := 1; i < len(ranges); i += 2 {
+		longest = max(longest, utf8.RuneLen(ranges[i]))
+	}
+	return int64(longest)
+}
+
+// rangesMinLen returns the min length in bytes of a UTF-8 encoded rune in a set of sorted rune ranges, or 0 if there
+// are none.
+func rangesMinLen(ranges []rune) int64 {
+	if len(ranges) == 0 {
+		return 0
+	}
+	return int64(utf8.RuneLen(ranges[0]))
+}
+
+// rangesMeanLen returns the mean length in bytes of the UTF-8 encoded runes in a set of sorted rune ranges, or 0 if
+// there are none.
+func rangesMeanLen(ranges []rune) float64 {
+	// Upper bounds of the runes encoded in 1, 2, 3, and 4 bytes.
+	bounds := [...]rune{0x7F, 0x7FF, 0xFFFF, unicode.MaxRune}
+	count, sum := 0.0, 0.0
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo := ranges[i]
+		for n, hi := range bounds {
+			if lo > hi {
+				continue
+			}
+			end := min(ranges[i+1], hi)
+			if end < lo {
+				break
+			}
+			k := float64(end - lo + 1)
+			count += k
+			sum += k * float64(n+1)
+			lo = end + 1
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+func satAdd(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+func satMul(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"bytes"
+	"fmt"
+	"regexp/syntax"
+	"unicode"
+)
+
+// AfterPrefix returns a regexp matching the rest of each string that rx matches and that starts with prefix, such that
+// prefix followed by a string generated from the result is a match for rx. For example, after the prefix "ab", the
+// regexp (abc|b)d* becomes cd*. It returns an error if rx can't match any string starting with prefix.
+//
+// Zero-width assertions other than the end of text (such as ^ and \b) are assumed to hold where prefix passes them.
+// The result shares unchanged sub-expressions with rx, so weights set for rx's alternations still apply to them.
+func AfterPrefix(rx *syntax.Regexp, prefix string) (*syntax.Regexp, error) {
+	for _, r := range prefix {
+		rx = derive(rx, r)
+		if rx.Op == syntax.OpNoMatch {
+			return nil, fmt.Errorf("regen: no string matching the regexp starts with %q", prefix)
+		}
+	}
+	return rx, nil
+}
+
+// GenerateWithPrefix returns a string that starts with prefix and should, ideally, be a match for rx, using the regexp
+// returned by AfterPrefix to generate the rest of it. MinLen and MaxLen only apply to the part after prefix.
+func (g *Generator) GenerateWithPrefix(rx *syntax.Regexp, prefix string) (string, error) {
+	rest, err := AfterPrefix(rx, prefix)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	if err := g.GenString(&buf, rest); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// derive returns a regexp matching what follows r in the strings matched by rx (its Brzozowski derivative), or an
+// OpNoMatch if no string matched by rx starts with r.
+func derive(rx *syntax.Regexp, r rune) *syntax.Regexp {
+	switch rx.Op {
+	case syntax.OpLiteral:
+		if len(rx.Rune) == 0 || !(rx.Rune[0] == r || rx.Flags&syntax.FoldCase != 0 && equalFold(rx.Rune[0], r)) {
+			return noMatch()
+		} else if len(rx.Rune) == 1 {
+			return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+		}
+		return &syntax.Regexp{Op: syntax.OpLiteral, Flags: rx.Flags, Rune: rx.Rune[1:]}
+	case syntax.OpCharClass:
+		if !validRanges(rx.Rune) || !inRanges(r, normalizeRanges(rx.Rune)) {
+			return noMatch()
+		}
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case syntax.OpAnyCharNotNL:
+		if r == '\n' {
+			return noMatch()
+		}
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case syntax.OpAnyChar:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case syntax.OpCapture:
+		c := *rx
+		c.Sub = []*syntax.Regexp{derive(rx.Sub[0], r)}
+		if c.Sub[0].Op == syntax.OpNoMatch {
+			return noMatch()
+		}
+		return &c
+	case syntax.OpConcat:
+		// The rune is either consumed by the first sub-expression, or by the rest if the first can match nothing.
+		var alts []*syntax.Regexp
+		for i, sub := range rx.Sub {
+			alts = append(alts, concatOf(append([]*syntax.Regexp{derive(sub, r)}, rx.Sub[i+1:]...)))
+			if !nullable(sub) {
+				break
+			}
+		}
+		return alternateOf(alts)
+	case syntax.OpAlternate:
+		alts := make([]*syntax.Regexp, len(rx.Sub))
+		for i, sub := range rx.Sub {
+			alts[i] = derive(sub, r)
+		}
+		return alternateOf(alts)
+	case syntax.OpQuest:
+		return derive(rx.Sub[0], r)
+	case syntax.OpStar:
+		return concatOf([]*syntax.Regexp{derive(rx.Sub[0], r), rx})
+	case syntax.OpPlus:
+		star := &syntax.Regexp{Op: syntax.OpStar, Flags: rx.Flags, Sub: rx.Sub}
+		return concatOf([]*syntax.Regexp{derive(rx.Sub[0], r), star})
+	case syntax.OpRepeat:
+		if rx.Max == 0 {
+			return noMatch()
+		}
+		rest := &syntax.Regexp{Op: syntax.OpRepeat, Flags: rx.Flags, Sub: rx.Sub, Min: max(rx.Min-1, 0), Max: rx.Max}
+		if rx.Max > 0 {
+			rest.Max--
+		}
+		return concatOf([]*syntax.Regexp{derive(rx.Sub[0], r), rest})
+	default:
+		// Empty matches and zero-width assertions can't consume a rune.
+		return noMatch()
+	}
+}
+
+// nullable returns whether rx can match an empty string. Zero-width assertions are assumed to hold.
+func nullable(rx *syntax.Regexp) bool {
+	switch rx.Op {
+	case syntax.OpNoMatch, syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return false
+	case syntax.OpLiteral:
+		return len(rx.Rune) == 0
+	case syntax.OpStar, syntax.OpQuest:
+		return true
+	case syntax.OpRepeat:
+		return rx.Min == 0 || nullable(rx.Sub[0])
+	case syntax.OpPlus, syntax.OpCapture:
+		return nullable(rx.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range rx.Sub {
+			if !nullable(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range rx.Sub {
+			if nullable(sub) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// concatOf returns the concatenation of subs, leaving out empty matches. It returns an OpNoMatch if any of subs is one.
+func concatOf(subs []*syntax.Regexp) *syntax.Regexp {
+	var out []*syntax.Regexp
+	for _, sub := range subs {
+		switch sub.Op {
+		case syntax.OpNoMatch:
+			return sub
+		case syntax.OpEmptyMatch:
+		default:
+			out = append(out, sub)
+		}
+	}
+	switch len(out) {
+	case 0:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case 1:
+		return out[0]
+	}
+	return &syntax.Regexp{Op: syntax.OpConcat, Sub: out}
+}
+
+// alternateOf returns the alternation of subs, leaving out those that can't match anything.
+func alternateOf(subs []*syntax.Regexp) *syntax.Regexp {
+	var out []*syntax.Regexp
+	for _, sub := range subs {
+		if sub.Op != syntax.OpNoMatch {
+			out = append(out, sub)
+		}
+	}
+	switch len(out) {
+	case 0:
+		return noMatch()
+	case 1:
+		return out[0]
+	}
+	return &syntax.Regexp{Op: syntax.OpAlternate, Sub: out}
+}
+
+func noMatch() *syntax.Regexp {
+	return &syntax.Regexp{Op: syntax.OpNoMatch}
+}
+
+// equalFold returns whether a and b are the same rune under simple case folding.
+func equalFold(a, b rune) bool {
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
@@ -1,269 +1,1302 @@
 // Copyright 2016 Noel Cower. All rights reserved.
 // Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
 
-// regen is a tool to parse and generate random strings from regular expressions.
+// Package regen generates random strings from parsed regular expressions.
 //
-//  go get go.spiff.io/regen
+// regen works by walking the op tree of a regular expression parsed by regexp/syntax. It is currently not guaranteed
+// to produce entirely accurate results, but will at least try.
 //
-// regen works by parsing a regular expression and walking its op tree. It is currently not guaranteed to produce
-// entirely accurate results, but will at least try.
+// Word boundaries (\b and \B) are handled on a best-effort basis by constraining the next generated rune to be a word
+// or non-word character, if possible, or else writing a separator before it (so \Bfoo generates a word character
+// before foo, and foo\B one after it). End-of-text markers ($ and \z) truncate the generated string at the point they
+// were reached. Line anchors (^ and $ in multi-line mode) write newlines where needed to start or end a line.
 //
-// Currently, word boundaries are not supported (until I decide how best to randomly insert a word boundary character).
-// Using a word boundary op (\b or \B) will currently cause regen to panic. In addition, line endings are also poorly
-// supported right now and EOT markers are treated as the end of string generation.
+// The regen command, which wraps this package, can be installed with:
 //
-// Usage is simple, pass one or more regular expressions to regen on the command line and it will generate a string from
-// each, printing them in the same order as on the command line (separated by newlines):
-//
-//      $ regen 'foo(-(bar|baz|quux|woop)){4}'
-//      foo-woop-quux-bar-quux
-//
-// So, if you fancy yourself a Javascript weirdo of some variety, you can at least use regen to write code for eBay:
-//
-//      $ regen '!{0,5}\[\](\[(!\[\](\+!{1,2}\[\]))\]|\+!{0,5}\[(\[\])?\]|\+\{\})+'
-//      ![]+!!![[]]+{}[![]+!![]][![]+!![]]+{}[![]+![]]+{}+{}[![]+![]][![]+!![]]+![[]]+{}
-//
-// A few command-line options are provided, which you can see by running regen -help.
-package main
+//	go install go.spiff.io/regen/cmd/regen@latest
+package regen
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
-	"flag"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"math/big"
-	"os"
 	"regexp/syntax"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-// CLI options
+// DefaultUnboundMax is the default max number of repetitions used for unbounded repetitions.
+const DefaultUnboundMax = 32
+
+// DefaultMaxRepeat is the default max number of times any repetition is repeated. It is far beyond any count the
+// regexp/syntax parser accepts, and only keeps repetition counts from overflowing.
+const DefaultMaxRepeat = math.MaxInt32
+
+// DefaultQuestProb is the default probability of including an optional (?) sub-expression.
+const DefaultQuestProb = 0.5
+
+// DefaultNewlineProb is the default probability of . generating a newline in dot-all mode (?s).
+const DefaultNewlineProb = 1.0 / 96
+
+// minLenAttempts is the number of times to try generating a string of at least MinLen bytes (or runes), or one that
+// doesn't start with a combining mark if NoLeadingMarks is set.
+const minLenAttempts = 10
+
+// Generator generates strings from parsed regular expressions.
+//
+// A Generator keeps state between calls (e.g., its Captures and Choices), so it must not be used by more than one
+// goroutine at a time. To generate strings concurrently, give each goroutine its own Generator with Clone. Parsed
+// regexps aren't modified by generating strings, so they may be shared.
+type Generator struct {
+	// UnboundMax is the max number of repetitions to use for unlimited repetitions/matches (*, +, and {n,}).
+	UnboundMax int
+
+	// MaxRepeat is the max number of times any repetition is repeated, including unbounded repetitions and those with
+	// bounds out of range (e.g., in a hand-built regexp). If zero, DefaultMaxRepeat is used.
+	MaxRepeat int
+
+	// UnboundMin is the min number of repetitions to use for unlimited repetitions/matches. It raises the min of *
+	// (otherwise 0), + (otherwise 1), and {n,} (otherwise n) when it's greater.
+	UnboundMin int
+
+	// QuestProb is the probability, from 0 to 1, of including the sub-expression of an optional (?) op.
+	QuestProb float64
+
+	// NewlineProb is the probability, from 0 to 1, of . generating a newline when it matches newlines (in dot-all mode,
+	// (?s)). Otherwise, it generates a printable ASCII character. It doesn't apply when . is generated from other
+	// ranges of runes (e.g., with Unicode or DotRanges), where a newline is as likely as any other rune. Without (?s),
+	// . never generates a newline.
+	NewlineProb float64
+
+	// NewlineRune, if not zero, is generated by . in place of a newline in dot-all mode, both when drawn with
+	// NewlineProb and when . is generated from other ranges of runes (e.g., DotRanges that include a newline). To keep .
+	// from generating newlines at all, set NewlineProb to zero and leave newlines out of DotRanges instead.
+	NewlineRune rune
+
+	// Simplify, if true, causes regexps to be simplified (see syntax.Regexp.Simplify) before generating strings from
+	// them. Simplifying turns counted repetitions like x{2,4} into chains of optional parts (xx(?:xx?)?), so the length
+	// of each chain is chosen using Dist, the same as the repetition it came from, instead of QuestProb.
+	Simplify bool
+
+	// RepeatBudget, if greater than zero, limits the product of the repetition counts of nested repetitions (*, +, and
+	// {m,n}) along any path through a regexp. The max count of a repetition is reduced to fit the budget left by the
+	// repetitions it's nested in, but never below its min, so that a pattern like (a*b*)* can't multiply out to a huge
+	// string. Count and Lengths don't account for it.
+	RepeatBudget int
+
+	// Dist is the distribution used to choose repetition counts. Defaults to Uniform.
+	Dist Distribution
+
+	// Rand is the source of random bytes used by the Generator. If nil, crypto/rand's Reader is used.
+	Rand io.Reader
+
+	// Unicode, if true, causes . to generate any valid Unicode code point (excluding surrogates) instead of printable
+	// ASCII characters.
+	Unicode bool
+
+	// Bytes, if true, causes char classes and . to generate byte values (runes 0 to 0xFF) written as single bytes
+	// instead of UTF-8 encoded runes, so that [\x80-\xFF] generates raw bytes for binary data. Literal runes up to 0xFF
+	// are also written as single bytes. Generated strings may then not be valid UTF-8, so they generally won't match
+	// their regexp with package regexp, and lengths from Lengths and EstimateLength and strings from Enumerate are
+	// still those of UTF-8 text. With Unicode, . generates any byte value.
+	Bytes bool
+
+	// DotRanges, if not nil, is the set of runes . generates, given as sorted, non-overlapping pairs of low and high
+	// runes (the same as the Rune field of an OpCharClass). Newlines are excluded unless . matches them. DotRanges takes
+	// precedence over Unicode.
+	DotRanges []rune
+
+	// ASCII, if true, restricts all characters generated by char classes and . to printable ASCII characters. If a
+	// char class has no printable ASCII characters, generation fails with an error.
+	ASCII bool
+
+	// Frequencies, if not nil, weights the runes picked from char classes and . by the table's relative frequencies.
+	// Only the runes in the table are picked from a char class that has any of them (e.g., [a-z0-9] only generates
+	// letters with EnglishFrequencies); other char classes are sampled uniformly.
+	Frequencies *FrequencyTable
+
+	// Sampler, if not nil, picks the runes generated by char classes and . in place of UniformSampler. It's given the
+	// ranges left after applying ASCII, DotRanges, and word boundaries. If Frequencies is also set, Sampler is only
+	// used for char classes that have none of the table's runes.
+	Sampler Sampler
+
+	// Weights maps OpAlternate nodes of a parsed regexp to the relative weights of their sub-expressions. If an
+	// alternation has no weights, or its weights are invalid (not one per sub-expression, negative, or summing to
+	// zero), each sub-expression is equally likely to be chosen.
+	Weights map[*syntax.Regexp][]int
+
+	// Debug, if non-nil, receives a description of each random choice made during generation (alternation branches,
+	// repetition counts, and so on), one per line.
+	Debug io.Writer
+
+	// MinLen, if greater than zero, is the min length in bytes of a generated string. While a string is shorter than
+	// MinLen, optional sub-expressions are always included and repetitions continue past their chosen count (up to their
+	// max). If the string is still too short, generation is retried a few times before failing with an error.
+	MinLen int
+
+	// MaxLen, if greater than zero, is the max length in bytes of a generated string. Once it is reached, repetitions
+	// stop expanding beyond their minimum count. Mandatory parts of a pattern may still exceed it.
+	MaxLen int
+
+	// NoLeadingMarks, if true, causes strings that start with a combining mark (such as U+0301, from \pM or Unicode)
+	// to be generated again, so that they don't start with a mark that has nothing to combine with. If every attempt
+	// starts with one (e.g., for ^\pM), the last is kept.
+	NoLeadingMarks bool
+
+	// NormalizeNFC, if true, causes generated strings to be converted to Unicode Normalization Form C, composing
+	// combining marks with the characters before them where possible. It's applied once a string has been generated,
+	// so MinLen and MaxLen measure the string before normalizing, and Captures aren't normalized. Strings streamed by
+	// GenWriter aren't normalized once part of them has been written.
+	NormalizeNFC bool
+
+	// CountRunes, if true, causes MinLen and MaxLen to be measured in runes instead of bytes.
+	CountRunes bool
+
+	// CycleAlternates, if true, causes each OpAlternate to generate its sub-expressions in order, one per time it's
+	// generated, instead of choosing one at random. The Generator remembers where each alternation left off across
+	// calls, so generating enough strings from the same parsed regexp covers every branch. Weights are ignored. Note
+	// that the parser turns alternations of single characters, such as a|b|c, into char classes, which aren't cycled.
+	CycleAlternates bool
+
+	// TrimAnchorNewlines, if true, omits the newlines that would otherwise be written only to satisfy line anchors (^
+	// and $ in multi-line mode). Generated strings may then not match their pattern.
+	TrimAnchorNewlines bool
+
+	// MaxDepth, if greater than zero, is the max number of levels regexps may be nested, counting each op with
+	// sub-expressions as a level. Generating strings from deeper regexps fails with an error before anything is
+	// generated, so regexps from untrusted sources (especially hand-built or expanded ones, which the parser's own
+	// nesting limit doesn't apply to) can't recurse deeply enough to exhaust the stack. The same limit applies to the
+	// other methods that walk a regexp: Enumerate fails the same way, while Count and Lengths return zero.
+	MaxDepth int
+
+	// Strict, if true, causes generation to fail with an *UnsupportedError (see Check) for regexps containing ops that
+	// are only handled on a best-effort basis, instead of generating strings that may not match.
+	Strict bool
+
+	// Trace, if true, causes each random choice made during generation to be recorded. The choices made by the most
+	// recent call to GenString or Generate are returned by Choices.
+	Trace bool
+
+	// Replay, if not nil, holds choices to make in order instead of drawing random numbers, such as those returned by
+	// Choices. A replayed choice's N is taken modulo the number of possible values of the choice it replaces, so
+	// replaying an edited trace still generates a valid string. Once Replay runs out, choices are random again. Each
+	// call to GenString or Generate replays from the start of Replay.
+	Replay []Choice
+
+	// simplified is the result of simplifying the regexp simplifiedFrom, so that a regexp generated from repeatedly
+	// with Simplify set is only simplified once.
+	simplified, simplifiedFrom *syntax.Regexp
+
+	// buf is the buffer AppendString generates into, kept in the Generator so that it isn't allocated for each call.
+	buf bytes.Buffer
+
+	// ctx is the context of the current call to GenStringContext or GenWriter.
+	ctx context.Context
+	// eol is true when OpEndLine has been reached and the next rune written must be a newline.
+	eol bool
+	// captures holds the text generated for each capture group, keyed by capture index.
+	captures map[int]string
+	// named maps the names of named capture groups that were generated to their capture indices.
+	named map[string]int
+
+	// next is a pending constraint on the next rune written, set by word boundary ops.
+	next wordConstraint
+	// wordSep is whether separate writes a word character when the next rune doesn't satisfy next.
+	wordSep bool
+
+	// op is the op currently being generated, recorded with each choice.
+	op syntax.Op
+	// trace holds the choices made so far, if Trace is set.
+	trace []Choice
+	// replayed is the number of choices taken from Replay so far.
+	replayed int
+	// dead holds the sub-expressions of the regexp being generated that can't match anything, or nil if there are none.
+	dead map[*syntax.Regexp]bool
+	// randErr is the first error reading from Rand during the current call, which stops generation.
+	randErr error
+	// product is the product of the repetition counts of the repetitions being generated, for RepeatBudget.
+	product int
+	// cycles holds the index of the next sub-expression to generate for each OpAlternate, if CycleAlternates is set.
+	cycles map[*syntax.Regexp]int
+	// covered holds the branches of each OpAlternate taken so far by CoverAlternates, or nil outside of it.
+	covered map[*syntax.Regexp][]bool
+	// taken holds the branches taken by the current attempt at generating a string, if covered is set.
+	taken []branch
+}
+
+// Choice is a single random choice made during generation: an alternation branch, a repetition count, a rune from a
+// char class, and so on.
+type Choice struct {
+	Op  syntax.Op // The op the choice was made for.
+	N   int64     // The value chosen, in the range [0, Max).
+	Max int64     // The number of possible values.
+}
 
-var verbose bool
-var unboundMax = 32
+// wordConstraint describes whether the next rune generated must be a word or non-word character.
+type wordConstraint int
 
-func randint(max int64) int64 {
+const (
+	anyNext wordConstraint = iota
+	wordNext
+	nonWordNext
+)
+
+// wordRanges and nonWordRanges are the rune ranges matched by \w and \W, respectively.
+var (
+	wordRanges    = []rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}
+	nonWordRanges = []rune{0, '0' - 1, '9' + 1, 'A' - 1, 'Z' + 1, '_' - 1, '_' + 1, 'a' - 1, 'z' + 1, unicode.MaxRune}
+
+	// sepRanges are the printable non-word runes written as separators for word boundaries.
+	sepRanges = []rune{' ', '/', ':', '@', '[', '^', '`', '`', '{', '~'}
+)
+
+// Rune ranges used by . (OpAnyChar and OpAnyCharNotNL).
+var (
+	newlineRanges      = []rune{'\n', '\n'}
+	notNLRanges        = []rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}
+	printRanges        = []rune{' ', '~'}
+	printNLRanges      = []rune{'\n', '\n', ' ', '~'}
+	unicodeRanges      = []rune{0, 0xD7FF, 0xE000, unicode.MaxRune}
+	unicodeNotNLRanges = []rune{0, '\n' - 1, '\n' + 1, 0xD7FF, 0xE000, unicode.MaxRune}
+)
+
+// byteRanges is the range of runes char classes and . may generate if Bytes is set.
+var byteRanges = []rune{0, 0xFF}
+
+// New allocates a new Generator with default options, using crypto/rand as its source of randomness.
+func New() *Generator {
+	return &Generator{
+		UnboundMax:  DefaultUnboundMax,
+		QuestProb:   DefaultQuestProb,
+		NewlineProb: DefaultNewlineProb,
+		Rand:        rand.Reader,
+	}
+}
+
+// Clone returns a new Generator with the same options as g but none of its state, such as its Captures, Choices, or
+// where CycleAlternates left off, so that it can be used concurrently with g. The clone shares g's Rand, Debug,
+// Weights, Frequencies, Sampler, and Replay: Weights and Replay are only read, but Rand, Debug, and Sampler must be
+// safe for concurrent use if g and the clone are used at the same time. crypto/rand.Reader is, but a *math/rand.Rand
+// isn't, so give each clone its own instead.
+func (g *Generator) Clone() *Generator {
+	c := *g
+	c.simplified, c.simplifiedFrom = nil, nil
+	c.buf = bytes.Buffer{}
+	c.ctx = nil
+	c.eol = false
+	c.captures, c.named = nil, nil
+	c.next, c.wordSep, c.op = anyNext, false, 0
+	c.trace, c.replayed = nil, 0
+	c.dead = nil
+	c.randErr = nil
+	c.product = 0
+	c.cycles = nil
+	c.covered, c.taken = nil, nil
+	return &c
+}
+
+// int63Source is implemented by sources of randomness, such as *math/rand.Rand, that can produce uniform random 63-bit
+// integers without going through crypto/rand.Int.
+type int63Source interface {
+	Int63() int64
+}
+
+// int63n returns a uniformly distributed random integer in the range [0, n) from src, which must be greater than 0.
+//
+// Taking a random 63-bit integer modulo n would make smaller results more likely whenever n doesn't evenly divide
+// 2**63, so draws from the incomplete last multiple of n (those greater than max) are rejected and redrawn. At most
+// half of all draws can be rejected, so this takes fewer than two draws on average. This is the same method used by
+// math/rand's Int63n, so it produces the same results for a *math/rand.Rand.
+func int63n(src int63Source, n int64) int64 {
+	if n&(n-1) == 0 {
+		// Powers of two evenly divide 2**63, so the low bits are already uniform.
+		return src.Int63() & (n - 1)
+	}
+	max := int64((1 << 63) - 1 - (1<<63)%uint64(n))
+	v := src.Int63()
+	for v > max {
+		v = src.Int63()
+	}
+	return v % n
+}
+
+// randint returns a random integer in the range [0, max), taken from Replay if it has choices left, and records it if
+// Trace is set.
+func (g *Generator) randint(max int64) int64 {
 	if max < 0 {
 		panic("randint: max < 0")
 	} else if max <= 1 {
 		return 0
 	}
+
+	var n int64
+	if g.replayed < len(g.Replay) {
+		n = g.Replay[g.replayed].N % max
+		if n < 0 {
+			n += max
+		}
+		g.replayed++
+	} else {
+		n = g.draw(max)
+	}
+	if g.Trace {
+		g.trace = append(g.trace, Choice{Op: g.op, N: n, Max: max})
+	}
+	return n
+}
+
+// draw returns a uniformly distributed random integer in the range [0, max) read from the Generator's Rand. If reading
+// from Rand fails, it records the error in randErr, which stops generation, and returns 0.
+func (g *Generator) draw(max int64) int64 {
+	r := g.Rand
+	if r == nil {
+		r = rand.Reader
+	} else if src, ok := r.(int63Source); ok {
+		// Fast path: avoid allocating a big.Int for non-crypto sources.
+		return int63n(src, max)
+	}
+
+	// crypto/rand.Int is also unbiased, as it rejects draws that are out of range.
 	var bigmax big.Int
 	bigmax.SetInt64(max)
-	res, err := rand.Int(rand.Reader, &bigmax)
+	res, err := rand.Int(r, &bigmax)
 	if err != nil {
-		panic(err)
+		if g.randErr == nil {
+			g.randErr = fmt.Errorf("regen: reading random number: %w", err)
+		}
+		return 0
 	}
 	return res.Int64()
 }
 
-// GenString writes a response that should, ideally, be a match for rx to w, and proceeds to do the same for its
-// sub-expressions where applicable. Returns io.EOF if it encounters OpEndText. This may not be entirely correct
-// behavior for OpEndText handling. Otherwise, returns nil.
-func GenString(w *bytes.Buffer, rx *syntax.Regexp) (err error) {
-	switch rx.Op {
-	case syntax.OpNoMatch:
-		return
-	case syntax.OpEmptyMatch:
-		return
-	case syntax.OpLiteral:
-		w.WriteString(string(rx.Rune))
-	case syntax.OpCharClass:
-		sum := 0
-		for i := 0; i < len(rx.Rune); i += 2 {
-			sum += 1 + int(rx.Rune[i+1]-rx.Rune[i])
+// randfloat returns a uniformly distributed random number in the range [0, 1).
+func (g *Generator) randfloat() float64 {
+	return float64(g.randint(1<<53)) / (1 << 53)
+}
+
+// ParseError is returned when a pattern fails to parse, to tell it apart from errors generating strings.
+type ParseError struct {
+	Pattern string // The pattern that failed to parse.
+	Err     error  // The error from parsing the pattern, usually a *syntax.Error.
+}
+
+func (e *ParseError) Error() string {
+	return "regen: " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses pattern using the given syntax flags, the same as syntax.Parse, except that errors are returned as a
+// *ParseError and, with Perl syntax (syntax.PerlX), inline comments are removed first (see StripComments).
+func Parse(pattern string, flags syntax.Flags) (*syntax.Regexp, error) {
+	expr := pattern
+	if flags&syntax.PerlX != 0 && flags&syntax.Literal == 0 {
+		expr = StripComments(pattern)
+	}
+	rx, err := syntax.Parse(expr, flags)
+	if err != nil {
+		return nil, &ParseError{Pattern: pattern, Err: err}
+	}
+	return rx, nil
+}
+
+// StripComments returns pattern with its Perl-style inline comments, (?#...), removed. regexp/syntax doesn't support
+// them, so they would otherwise fail to parse. A comment ends at the first ), as in Perl. Escaped parentheses, char
+// classes, and text quoted by \Q...\E are left alone, as is a comment that isn't closed.
+func StripComments(pattern string) string {
+	if !strings.Contains(pattern, "(?#") {
+		return pattern
+	}
+	return rewritePattern(pattern, func(_ *strings.Builder, rest string) int {
+		if !strings.HasPrefix(rest, "(?#") {
+			return 0
 		}
+		return strings.IndexByte(rest, ')') + 1
+	})
+}
 
-		for i, nth := 0, rune(randint(int64(sum))); i < len(rx.Rune); i += 2 {
-			min, max := rx.Rune[i], rx.Rune[i+1]
-			delta := max - min
-			if nth <= delta {
-				w.WriteRune(min + nth)
-				return nil
+// rewritePattern returns pattern with parts of it rewritten by rewrite. Escapes, char classes, and text quoted by
+// \Q...\E are copied as-is. At every other byte, rewrite is called with the rest of the pattern: it may write a
+// replacement to sb and return the number of bytes replaced, or return 0 to copy the byte.
+func rewritePattern(pattern string, rewrite func(sb *strings.Builder, rest string) int) string {
+	var sb strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && strings.HasPrefix(pattern[i:], `\Q`):
+			// Copy quoted text through the closing \E, or the rest of the pattern if there isn't one.
+			end := strings.Index(pattern[i+2:], `\E`)
+			if end == -1 {
+				end = len(pattern) - i - 2
+			} else {
+				end += 2
+			}
+			sb.WriteString(pattern[i : i+2+end])
+			i += 1 + end
+			continue
+		case c == '\\' && i+1 < len(pattern):
+			sb.WriteString(pattern[i : i+2])
+			i++
+			continue
+		case inClass && c == '[' && strings.HasPrefix(pattern[i+1:], ":"):
+			// Copy POSIX classes, such as [:alpha:], whole so that their ] doesn't end the class.
+			if end := strings.Index(pattern[i:], ":]"); end > 0 {
+				sb.WriteString(pattern[i : i+end+2])
+				i += end + 1
+				continue
+			}
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			// Copy the start of the class, including a ^ and a ] right after it, which is part of the class.
+			j := i + 1
+			if j < len(pattern) && pattern[j] == '^' {
+				j++
+			}
+			if j < len(pattern) && pattern[j] == ']' {
+				j++
+			}
+			sb.WriteString(pattern[i:j])
+			i = j - 1
+			inClass = true
+			continue
+		default:
+			if n := rewrite(&sb, pattern[i:]); n > 0 {
+				i += n - 1
+				continue
 			}
-			nth -= 1 + delta
 		}
-		panic("unreachable")
-	case syntax.OpAnyCharNotNL:
-		w.WriteRune(rune(' ' + randint(95)))
-	case syntax.OpAnyChar:
-		i := int(randint(96))
-		ch := rune(' ' + i)
-		if i == 95 {
-			ch = '\n'
-		}
-		w.WriteRune(ch)
-	case syntax.OpBeginLine:
-		if w.Len() != 0 {
-			w.WriteByte('\n')
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// Generate parses pattern using the given syntax flags and returns a string generated from it using a default
+// Generator. Parse errors are returned as a *ParseError.
+func Generate(pattern string, flags syntax.Flags) (string, error) {
+	rx, err := Parse(pattern, flags)
+	if err != nil {
+		return "", err
+	}
+	return New().Generate(rx)
+}
+
+// Generate returns a string that should, ideally, be a match for rx.
+func (g *Generator) Generate(rx *syntax.Regexp) (string, error) {
+	var b bytes.Buffer
+	err := g.GenString(&b, rx)
+	return b.String(), err
+}
+
+// GenerateN returns n strings generated from rx, reusing a single buffer between them. If an error occurs, the strings
+// generated before it are returned along with the error.
+func (g *Generator) GenerateN(rx *syntax.Regexp, n int) ([]string, error) {
+	results := make([]string, 0, n)
+	err := g.GenerateFunc(rx, n, func(s string) error {
+		results = append(results, s)
+		return nil
+	})
+	return results, err
+}
+
+// GenerateFunc generates n strings from rx, reusing a single buffer between them, and calls fn with each one as it's
+// generated. Generation stops at the first error, either from generating a string or returned by fn, which is
+// returned. Since strings aren't kept once fn returns, memory use doesn't grow with n.
+func (g *Generator) GenerateFunc(rx *syntax.Regexp, n int, fn func(string) error) error {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		b.Reset()
+		if err := g.GenString(&b, rx); err != nil {
+			return err
 		}
-	case syntax.OpEndLine:
-		if w.Len() != 0 {
-			w.WriteByte('\n')
-		} else {
-			return io.EOF
+		if err := fn(b.String()); err != nil {
+			return err
 		}
-	case syntax.OpBeginText:
-	case syntax.OpEndText:
-		return io.EOF
-	case syntax.OpWordBoundary:
-		fallthrough
-	case syntax.OpNoWordBoundary:
-		panic("regen: word boundaries not supported yet")
-	case syntax.OpStar, syntax.OpPlus:
-		min := 0
-		if rx.Op == syntax.OpPlus {
-			min = 1
-		}
-		max := min + unboundMax
-
-		for sz := min + int(randint(int64(max)-int64(min)+1)); sz > 0; sz-- {
-			for _, rx := range rx.Sub {
-				GenString(w, rx)
-			}
+	}
+	return nil
+}
+
+// GenString writes a response that should, ideally, be a match for rx to w, and proceeds to do the same for its
+// sub-expressions where applicable.
+//
+// Line anchors are satisfied by writing newlines where needed: OpBeginLine writes a newline unless at the start of the
+// string or a line, and OpEndLine requires the next rune written (if any) to be a newline, writing one if necessary.
+//
+// If OpEndText is reached, generation continues (as the end of text may be inside of a repetition or alternation), but
+// anything written after the earliest end of text is discarded once generation is done.
+func (g *Generator) GenString(w *bytes.Buffer, rx *syntax.Regexp) (err error) {
+	return g.GenStringContext(context.Background(), w, rx)
+}
+
+// GenStringContext is the same as GenString, except that it stops generating and returns ctx's error if ctx is
+// cancelled. The context is checked before each repetition of a repeated sub-expression.
+func (g *Generator) GenStringContext(ctx context.Context, w *bytes.Buffer, rx *syntax.Regexp) (err error) {
+	return g.run(ctx, &sink{buf: w, base: w.Len()}, rx)
+}
+
+// AppendString appends a string generated from rx to dst and returns the extended slice, the same as GenString.
+//
+// AppendString is meant for generating many strings with one Generator: with a Rand that implements Int63 (such as a
+// *math/rand.Rand) and no Debug, Trace, or Sampler, it doesn't allocate unless dst has to grow or rx has capture
+// groups, so reusing dst between calls (e.g., passing dst[:0]) avoids allocating for each string. Like the rest of a
+// Generator, it is not safe for concurrent use.
+func (g *Generator) AppendString(dst []byte, rx *syntax.Regexp) ([]byte, error) {
+	g.buf = *bytes.NewBuffer(dst)
+	err := g.run(context.Background(), &sink{buf: &g.buf, base: len(dst)}, rx)
+	dst = g.buf.Bytes()
+	g.buf = bytes.Buffer{} // Don't hold on to dst.
+	return dst, err
+}
+
+// GenWriter is the same as GenString, except that it writes the generated string to w as it is generated, instead of
+// holding all of it in memory. Because text written to w can't be taken back, a few things behave differently when
+// streaming large strings: OpBeginText can only discard text that hasn't been written to w yet, generation is not
+// retried to satisfy MinLen once text has been written, and Captures only holds the parts of captures that hadn't been
+// written to w when the capture ended.
+func (g *Generator) GenWriter(w io.Writer, rx *syntax.Regexp) error {
+	s := &sink{buf: new(bytes.Buffer), w: w}
+	err := g.run(context.Background(), s, rx)
+	if ferr := s.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// run generates a string from rx and writes it to s.
+func (g *Generator) run(ctx context.Context, w *sink, rx *syntax.Regexp) (err error) {
+	if err := g.CheckDepth(rx); err != nil {
+		return err
+	}
+	if g.Strict {
+		if err := Check(rx); err != nil {
+			return err
 		}
-	case syntax.OpQuest:
-		if randint(0xFFFFFFFF) > 0x7FFFFFFF {
-			for _, rx := range rx.Sub {
-				if err := GenString(w, rx); err != nil {
-					return err
-				}
-			}
+	}
+
+	if g.MinLen > 0 {
+		if longest := g.maxLength(rx); longest < int64(g.MinLen) {
+			return fmt.Errorf("regen: %v generates at most %d bytes, less than the min length of %d", rx, longest, g.MinLen)
 		}
-	case syntax.OpRepeat:
-		min := rx.Min
-		max := rx.Max
-		if max == -1 {
-			max = min + unboundMax
+	}
+
+	if g.Simplify {
+		rx = g.simplify(rx)
+	}
+
+	g.dead = nil
+	g.randErr = nil
+	if g.markDead(rx) {
+		return fmt.Errorf("regen: %v can't match anything", rx)
+	}
+
+	g.ctx = ctx
+	g.product = 1
+	w.trim = g.TrimAnchorNewlines
+	g.trace = nil
+	g.replayed = 0
+	for attempt := 1; ; attempt++ {
+		g.next = anyNext
+		g.eol = false
+		g.captures = nil
+		g.named = nil
+		g.taken = g.taken[:0]
+		w.end = -1
+		if err = g.gen(w, rx); err == nil {
+			err = g.randErr // The last draw may have failed without anything after it to stop.
 		}
-		for sz := min + int(randint(int64(max)-int64(min)+1)); sz > 0; sz-- {
-			for _, rx := range rx.Sub {
-				if err := GenString(w, rx); err != nil {
-					return err
-				}
-			}
+		if err == nil && g.next == wordNext {
+			// The end of the text is a non-word character, so a trailing boundary needs a word character after it.
+			g.writeSep(w, true)
+		}
+		if w.end >= 0 {
+			w.Truncate(w.end)
 		}
+		if err != nil || (!g.short(w) && !g.leadingMark(w)) || attempt >= minLenAttempts || w.flushed > 0 {
+			break
+		}
+		w.Truncate(0)
+	}
 
-	case syntax.OpConcat, syntax.OpCapture:
-		for _, rx := range rx.Sub {
-			if err := GenString(w, rx); err != nil {
-				return err
-			}
+	if err == nil && g.short(w) {
+		unit := "bytes"
+		if g.CountRunes {
+			unit = "runes"
 		}
-	case syntax.OpAlternate:
-		nth := randint(int64(len(rx.Sub)))
-		return GenString(w, rx.Sub[nth])
+		return fmt.Errorf("regen: unable to generate a string of at least %d %s from %v", g.MinLen, unit, rx)
 	}
+	if err == nil && g.NormalizeNFC && w.flushed == 0 {
+		if b := w.buf.Bytes()[w.base:]; !norm.NFC.IsNormal(b) {
+			b = norm.NFC.Bytes(b)
+			w.buf.Truncate(w.base)
+			w.buf.Write(b)
+		}
+	}
+	return err
+}
 
-	return nil
+// Captures returns the text generated for each capture group by the most recent call to GenString or Generate, keyed by
+// capture index. If a capture group was generated more than once (e.g., inside of a repetition), the last text
+// generated for it is kept. Capture groups that were not generated (e.g., in an alternation branch not taken) are
+// absent from the map, which is nil if no capture groups were generated.
+func (g *Generator) Captures() map[int]string {
+	return g.captures
 }
 
-const usageText = `
-regen [OPTIONS] <pattern>...
+// Capture returns the text generated for the capture group with the given name, such as (?P<name>...), by the most
+// recent call to GenString or Generate. As with Captures, the last text generated for it is kept. ok is false if no
+// capture group with that name was generated.
+func (g *Generator) Capture(name string) (text string, ok bool) {
+	i, ok := g.named[name]
+	if !ok {
+		return "", false
+	}
+	return g.captures[i], true
+}
 
-<pattern> must be a valid POSIX- or Perl-compatible RE2 regular expression pattern. RE2's
-regular expression syntax is described at <https://github.com/google/re2/wiki/Syntax>.
+// Choices returns the random choices made by the most recent call to GenString or Generate, if Trace is set. Passing
+// them to another Generator as its Replay generates the same string from the same regexp.
+func (g *Generator) Choices() []Choice {
+	return g.trace
+}
 
-Note that when passing -simplify, this can convert {m,n} repetitions into chains of zero-or-one
-repetitions. This can produce less variance in result strings as zero-or-one repetitions are
-essentially a coin toss and will skip nested sub-expressions if the toss fails.
+// debugf writes a line describing a choice made during generation to g.Debug, if set. Callers check g.Debug first so
+// that its arguments aren't boxed when there's nowhere to write them.
+func (g *Generator) debugf(format string, args ...interface{}) {
+	if g.Debug != nil {
+		fmt.Fprintf(g.Debug, format+"\n", args...)
+	}
+}
 
-OPTIONS
--------
-`
+// CheckDepth returns an error if MaxDepth is set and rx is nested more than MaxDepth levels deep, in which case the
+// Generator refuses to generate strings from it. Otherwise, it returns nil.
+func (g *Generator) CheckDepth(rx *syntax.Regexp) error {
+	if g.MaxDepth > 0 && tooDeep(rx, g.MaxDepth) {
+		return fmt.Errorf("regen: regexp is nested more than %d levels deep", g.MaxDepth)
+	}
+	return nil
+}
 
-func main() {
-	log.SetPrefix("regen: ")
-	log.SetFlags(0)
+// tooDeep returns whether rx has sub-expressions nested more than depth levels deep. It only recurses up to depth
+// levels, so it's safe to call on arbitrarily deep regexps.
+func tooDeep(rx *syntax.Regexp, depth int) bool {
+	if depth == 0 {
+		return len(rx.Sub) > 0
+	}
+	for _, sub := range rx.Sub {
+		if tooDeep(sub, depth-1) {
+			return true
+		}
+	}
+	return false
+}
 
-	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, strings.TrimSpace(usageText))
-		flag.PrintDefaults()
+// leadingMark returns whether NoLeadingMarks is set and the string generated in w starts with a combining mark. Once
+// part of the string has been flushed, it's too late to retry it, so this returns false.
+func (g *Generator) leadingMark(w *sink) bool {
+	if !g.NoLeadingMarks || w.flushed > 0 {
+		return false
 	}
+	r, _ := utf8.DecodeRune(w.buf.Bytes()[w.base:])
+	return unicode.Is(unicode.M, r)
+}
+
+// short returns whether the string generated in w is shorter than MinLen.
+func (g *Generator) short(w *sink) bool {
+	return g.MinLen > 0 && g.length(w) < g.MinLen
+}
+
+// full returns whether the string generated in w has reached MaxLen.
+func (g *Generator) full(w *sink) bool {
+	return g.MaxLen > 0 && g.length(w) >= g.MaxLen
+}
 
-	simplify := flag.Bool("simplify", false, "Whether to simplify the parsed regular expressions.")
-	posix := flag.Bool("posix", false, "Use POSIX syntax instead of Perl-like syntax.")
-	zip := flag.Bool("zip", false, "Whether to interleave patterns or go pattern by pattern.")
-	n := flag.Uint("n", 1, "The `number` of strings to generate per regexp.")
-	flag.IntVar(&unboundMax, "max", unboundMax, "The max `repetitions` to use for unlimited repetitions/matches.")
-	flag.Parse()
+// length returns the length of the string generated in w in runes, if CountRunes is set, or bytes.
+func (g *Generator) length(w *sink) int {
+	if g.CountRunes {
+		return w.RuneLen()
+	}
+	return w.Len()
+}
 
-	if flag.NArg() == 0 {
-		log.Println("no regexp given")
+// writeRune writes r to w as a single byte if Bytes is set and r is a byte value, or UTF-8 encoded otherwise.
+func (g *Generator) writeRune(w *sink, r rune) {
+	if g.Bytes && r <= 0xFF {
+		w.WriteByte(byte(r))
 		return
 	}
+	w.WriteRune(r)
+}
+
+// endLine writes a newline to w if the next rune written, r, must be a newline and is not. The pending line ending is
+// cleared.
+func (g *Generator) endLine(w *sink, r rune) {
+	if g.eol {
+		g.eol = false
+		if r != '\n' {
+			w.anchorNewline()
+		}
+	}
+}
+
+// boundary sets the constraint on the next rune written to w for a word boundary op. The start of w is treated as a
+// non-word character.
+func (g *Generator) boundary(w *sink, op syntax.Op) {
+	last, ok := w.lastRune()
+	word := ok && syntax.IsWordChar(last)
+	if word == (op == syntax.OpWordBoundary) {
+		g.next = nonWordNext
+	} else {
+		g.next = wordNext
+	}
+	// For \b, the separator is what the next rune should have been. For \B, it's the same kind as the next rune.
+	g.wordSep = (g.next == wordNext) == (op == syntax.OpWordBoundary)
+}
+
+// separate clears the pending word boundary constraint before r is written to w. If r doesn't satisfy it (e.g., r is
+// from a literal), a separator is written first, so that the boundary holds right before r: \Bfoo at the start of a
+// string generates a word character before foo, and \b- a word character before the -. This can't help when the
+// boundary is between two literal runes (as in a\bb), which never match.
+func (g *Generator) separate(w *sink, r rune) {
+	if g.next != anyNext && syntax.IsWordChar(r) != (g.next == wordNext) {
+		g.writeSep(w, g.wordSep)
+	}
+	g.next = anyNext
+}
 
-	mode := syntax.Perl
-	if *posix {
-		mode = syntax.POSIX
+// writeSep writes a random word character to w if word is true, or else a printable non-word character.
+func (g *Generator) writeSep(w *sink, word bool) {
+	ranges := sepRanges
+	if word {
+		ranges = wordRanges
 	}
+	w.WriteRune(nthRune(ranges, g.randint(rangesLen(ranges))))
+}
 
-	regexen := make([]*syntax.Regexp, flag.NArg())
-	for i, s := range flag.Args() {
-		var err error
-		regexen[i], err = syntax.Parse(s, mode)
+// constrain returns the subset of ranges permitted by a pending word boundary constraint, if any. If no rune in ranges
+// satisfies the constraint, ranges is returned as-is, and separate writes a separator before the rune picked from them.
+func (g *Generator) constrain(ranges []rune) []rune {
+	var allowed []rune
+	switch g.next {
+	case wordNext:
+		allowed = wordRanges
+	case nonWordNext:
+		allowed = nonWordRanges
+	default:
+		return ranges
+	}
 
-		if err != nil {
-			log.Printf("error parsing regular expression %q:\n%v", s, err)
-			os.Exit(1)
+	if isect := intersectRanges(ranges, allowed); len(isect) > 0 {
+		return isect
+	}
+	return ranges
+}
+
+// validRanges returns whether ranges is a well-formed set of rune ranges: pairs of low and high runes, each pair in
+// order.
+func validRanges(ranges []rune) bool {
+	if len(ranges)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > ranges[i+1] {
+			return false
 		}
+	}
+	return true
+}
+
+// normalizeRanges returns ranges sorted with overlapping and adjacent ranges merged, so that each rune in ranges
+// appears exactly once. If ranges is already normalized, it is returned as-is. ranges must be valid (see validRanges).
+func normalizeRanges(ranges []rune) []rune {
+	normalized := true
+	for i := 2; i < len(ranges) && normalized; i += 2 {
+		normalized = ranges[i] > ranges[i-1]+1
+	}
+	if normalized {
+		return ranges
+	}
+
+	pairs := make([][2]rune, 0, len(ranges)/2)
+	for i := 0; i < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
 
-		if *simplify {
-			regexen[i] = regexen[i].Simplify()
+	out := make([]rune, 0, len(ranges))
+	for _, p := range pairs {
+		if n := len(out); n > 0 && p[0] <= out[n-1]+1 {
+			out[n-1] = max(out[n-1], p[1])
+			continue
 		}
+		out = append(out, p[0], p[1])
 	}
+	return out
+}
 
-	var b bytes.Buffer
-	first := true
-	if *zip {
-		for i := uint(0); i < *n; i++ {
-			for _, rx := range regexen {
-				if !first {
-					fmt.Print("\n")
-					b.Reset()
-				}
-				first = false
+// encodable returns whether every rune in ranges can be encoded as UTF-8: none are negative, surrogates, or greater
+// than unicode.MaxRune.
+func encodable(ranges []rune) bool {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if lo, hi := ranges[i], ranges[i+1]; lo < 0 || hi > unicode.MaxRune || lo <= 0xDFFF && hi >= 0xD800 {
+			return false
+		}
+	}
+	return true
+}
 
-				err := GenString(&b, rx)
-				if err != nil && err != io.EOF {
-					log.Printf("Error generating string: %v", err)
-					os.Exit(1)
-				}
-				fmt.Print(b.String())
+// intersectRanges returns the intersection of two sorted, non-overlapping sets of rune ranges (as used by
+// OpCharClass).
+func intersectRanges(a, b []rune) []rune {
+	var out []rune
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		lo, hi := max(a[i], b[j]), min(a[i+1], b[j+1])
+		if lo <= hi {
+			out = append(out, lo, hi)
+		}
+		if a[i+1] < b[j+1] {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return out
+}
+
+// alternate returns the index of the sub-expression of the OpAlternate rx to generate: one covering a new branch during
+// CoverAlternates, the next one in turn if CycleAlternates is set, or a random one using the weights for rx if any are
+// set.
+//
+// So that a seeded source of randomness always picks the same branches, how a random integer maps to a branch is fixed:
+// a single integer n is drawn from [0, live) and picks the n-th sub-expression that can match something, in order, or,
+// with weights, from [0, sum) and picks the first such sub-expression whose running total of weights exceeds n. Equal
+// weights therefore break ties by order, and nothing is drawn if only one sub-expression can match.
+func (g *Generator) alternate(rx *syntax.Regexp) int {
+	if g.covered != nil {
+		if nth, ok := g.coverBranch(rx); ok {
+			return nth
+		}
+	}
+	if g.CycleAlternates {
+		if g.cycles == nil {
+			g.cycles = make(map[*syntax.Regexp]int)
+		}
+		nth := g.cycles[rx] % len(rx.Sub)
+		for g.dead[rx.Sub[nth]] {
+			nth = (nth + 1) % len(rx.Sub)
+		}
+		g.cycles[rx] = nth + 1
+		return nth
+	}
+
+	weights := g.Weights[rx]
+	sum := int64(0)
+	for i, w := range weights {
+		if w < 0 {
+			sum = 0
+			break
+		} else if i < len(rx.Sub) && !g.dead[rx.Sub[i]] {
+			sum += int64(w)
+		}
+	}
+	if len(weights) != len(rx.Sub) || sum <= 0 {
+		// Choose uniformly between the sub-expressions that can match something.
+		live := int64(0)
+		for _, sub := range rx.Sub {
+			if !g.dead[sub] {
+				live++
 			}
 		}
-	} else {
-		for _, rx := range regexen {
-			for i := uint(0); i < *n; i++ {
-				if !first {
-					fmt.Print("\n")
-					b.Reset()
-				}
-				first = false
+		nth := g.randint(live)
+		for i, sub := range rx.Sub {
+			if g.dead[sub] {
+				continue
+			} else if nth == 0 {
+				return i
+			}
+			nth--
+		}
+		panic("unreachable")
+	}
 
-				err := GenString(&b, rx)
-				if err != nil && err != io.EOF {
-					log.Printf("Error generating string: %v", err)
-					os.Exit(1)
-				}
-				fmt.Print(b.String())
+	nth := g.randint(sum)
+	for i, w := range weights {
+		if g.dead[rx.Sub[i]] {
+			continue
+		} else if nth < int64(w) {
+			return i
+		}
+		nth -= int64(w)
+	}
+	panic("unreachable")
+}
+
+// simplify returns rx simplified, reusing the result for the last regexp simplified if rx is the same.
+func (g *Generator) simplify(rx *syntax.Regexp) *syntax.Regexp {
+	if rx != g.simplifiedFrom {
+		g.simplified, g.simplifiedFrom = rx.Simplify(), rx
+	}
+	return g.simplified
+}
+
+// questChain returns the number of optional parts in the chain of nested OpQuests headed by rx, as left by simplifying
+// a counted repetition: x{0,3} becomes (?:x(?:xx?)?)?, a chain of 3.
+func questChain(rx *syntax.Regexp) int {
+	n := 1
+	for {
+		sub := rx.Sub[0]
+		if sub.Op != syntax.OpConcat || len(sub.Sub) == 0 || sub.Sub[len(sub.Sub)-1].Op != syntax.OpQuest {
+			return n
+		}
+		rx = sub.Sub[len(sub.Sub)-1]
+		n++
+	}
+}
+
+// genChain generates the chain of n nested OpQuests headed by rx (see questChain), including a number of its parts
+// chosen by repeat, so that Dist applies to it the same as to the repetition it was simplified from.
+func (g *Generator) genChain(w *sink, rx *syntax.Regexp, n int) error {
+	sz := g.repeat(0, n)
+	if g.Debug != nil {
+		g.debugf("%v %v: include %d of %d", rx.Op, rx, sz, n)
+	}
+	for i := 0; i < n && !g.full(w) && (i < sz || g.short(w)); i++ {
+		if err := g.ctx.Err(); err != nil {
+			return err
+		}
+
+		sub := rx.Sub[0]
+		if g.dead[sub] {
+			break
+		} else if i == n-1 {
+			return g.gen(w, sub)
+		}
+
+		// Generate everything but the next quest in the chain.
+		for _, rx := range sub.Sub[:len(sub.Sub)-1] {
+			if err := g.gen(w, rx); err != nil {
+				return err
 			}
 		}
+		rx = sub.Sub[len(sub.Sub)-1]
 	}
+	return nil
+}
 
-	if isTTY() {
-		fmt.Print("\n")
+// foldRunes returns r and the other runes it matches case-insensitively. If ASCII is set, other runes that aren't
+// printable ASCII (such as the Kelvin sign, K) are left out.
+func (g *Generator) foldRunes(r rune) []rune {
+	folds := []rune{r}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if !g.ASCII || (f >= ' ' && f <= '~') {
+			folds = append(folds, f)
+		}
 	}
+	return folds
 }
 
-// isTTY attempts to determine whether the current stdout refers to a terminal.
-func isTTY() bool {
-	fi, err := os.Stdout.Stat()
-	if err != nil {
-		log.Println("Error getting Stat of os.Stdout:", err)
-		return true // Assume human readable
+// markDead adds rx and each of its sub-expressions that can't match anything to g.dead and returns whether rx can't
+// match anything. Such sub-expressions come from OpNoMatch and empty char classes, and are never chosen by alternations
+// or repeated by optional repetitions.
+func (g *Generator) markDead(rx *syntax.Regexp) bool {
+	dead := false
+	for _, sub := range rx.Sub {
+		if g.markDead(sub) {
+			dead = true
+		}
+	}
+
+	switch rx.Op {
+	case syntax.OpNoMatch:
+		dead = true
+	case syntax.OpCharClass:
+		dead = len(rx.Rune) == 0
+	case syntax.OpAlternate:
+		dead = true
+		for _, sub := range rx.Sub {
+			dead = dead && g.dead[sub]
+		}
+	case syntax.OpQuest:
+		dead = false
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		min, _ := g.repeatBounds(rx)
+		dead = dead && min > 0
 	}
-	return (fi.Mode() & os.ModeNamedPipe) != os.ModeNamedPipe
+
+	if dead {
+		if g.dead == nil {
+			g.dead = make(map[*syntax.Regexp]bool)
+		}
+		g.dead[rx] = true
+	}
+	return dead
+}
+
+// deadSubs returns whether any of rx's sub-expressions can't match anything.
+func (g *Generator) deadSubs(rx *syntax.Regexp) bool {
+	for _, sub := range rx.Sub {
+		if g.dead[sub] {
+			return true
+		}
+	}
+	return false
+}
+
+// anyRanges returns the rune ranges . may generate for the given op (either OpAnyChar or OpAnyCharNotNL), with a
+// newline replaced by NewlineRune if it's set.
+func (g *Generator) anyRanges(op syntax.Op) []rune {
+	ranges := g.dotRanges(op)
+	if op == syntax.OpAnyChar && g.NewlineRune != 0 && inRanges('\n', ranges) {
+		ranges = normalizeRanges(append(intersectRanges(ranges, notNLRanges), g.NewlineRune, g.NewlineRune))
+	}
+	return ranges
+}
+
+// dotRanges returns the rune ranges . may generate for the given op, before replacing newlines with NewlineRune.
+func (g *Generator) dotRanges(op syntax.Op) []rune {
+	switch {
+	case g.DotRanges != nil:
+		ranges := g.DotRanges
+		if validRanges(ranges) {
+			ranges = normalizeRanges(ranges)
+		}
+		if op == syntax.OpAnyCharNotNL {
+			ranges = intersectRanges(ranges, notNLRanges)
+		}
+		if g.ASCII {
+			ranges = intersectRanges(ranges, printRanges)
+		}
+		return ranges
+	case g.ASCII:
+		return printRanges
+	case g.Unicode && op == syntax.OpAnyChar:
+		return unicodeRanges
+	case g.Unicode:
+		return unicodeNotNLRanges
+	case op == syntax.OpAnyChar:
+		return printNLRanges
+	default:
+		return printRanges
+	}
+}
+
+func (g *Generator) gen(w *sink, rx *syntax.Regexp) (err error) {
+	if g.randErr != nil {
+		return g.randErr
+	}
+	g.op = rx.Op
+	switch rx.Op {
+	case syntax.OpNoMatch:
+		// Unmatchable sub-expressions are never chosen, so this is only reached by a malformed tree.
+		return fmt.Errorf("regen: %v can't match anything", rx)
+	case syntax.OpEmptyMatch:
+		return
+	case syntax.OpLiteral:
+		if rx.Flags&syntax.FoldCase != 0 {
+			// Case-insensitive literals match any case of each rune, so pick one at random for each.
+			for _, r := range rx.Rune {
+				folds := g.foldRunes(r)
+				r = folds[g.randint(int64(len(folds)))]
+				g.separate(w, r)
+				g.endLine(w, r)
+				g.writeRune(w, r)
+			}
+			break
+		}
+		if len(rx.Rune) > 0 {
+			g.separate(w, rx.Rune[0])
+			g.endLine(w, rx.Rune[0])
+		}
+		if g.Bytes {
+			for _, r := range rx.Rune {
+				g.writeRune(w, r)
+			}
+			break
+		}
+		w.WriteString(string(rx.Rune))
+	case syntax.OpCharClass:
+		ranges := rx.Rune
+		if len(ranges) == 0 {
+			return fmt.Errorf("regen: char class %v is empty", rx)
+		} else if !validRanges(ranges) {
+			return fmt.Errorf("regen: char class %v has malformed rune ranges %v", rx, ranges)
+		}
+		// Ranges from the parser are already sorted and non-overlapping, but hand-built ones may not be. Overlapping
+		// ranges would make the runes they share more likely to be picked. Surrogates, which hand-built ranges may
+		// include, are left out since they can't be encoded as UTF-8 and would be written as U+FFFD instead.
+		if ranges = normalizeRanges(ranges); !encodable(ranges) {
+			ranges = intersectRanges(ranges, unicodeRanges)
+		}
+		if len(ranges) == 0 {
+			return fmt.Errorf("regen: char class %v has no runes that can be encoded as UTF-8", rx)
+		} else if g.Bytes {
+			if ranges = intersectRanges(ranges, byteRanges); len(ranges) == 0 {
+				return fmt.Errorf("regen: char class %v has no byte values", rx)
+			}
+		}
+		if g.ASCII {
+			if ranges = intersectRanges(ranges, printRanges); len(ranges) == 0 {
+				return fmt.Errorf("regen: char class %v has no printable ASCII characters", rx)
+			}
+		}
+		if g.eol && len(intersectRanges(ranges, newlineRanges)) > 0 {
+			g.separate(w, '\n')
+			g.endLine(w, '\n')
+			w.WriteByte('\n')
+			return nil
+		}
+		ranges = g.constrain(ranges)
+		if g.Frequencies != nil {
+			if r, ok := g.Frequencies.pick(g, ranges); ok {
+				g.separate(w, r)
+				g.endLine(w, r)
+				g.writeRune(w, r)
+				return nil
+			}
+		}
+		var r rune
+		if g.Sampler != nil {
+			r = g.Sampler.SampleRune(ranges, g.randint)
+		} else {
+			// Same as UniformSampler, without allocating a func for g.randint.
+			r = nthRune(ranges, g.randint(rangesLen(ranges)))
+		}
+		if !inRanges(r, ranges) {
+			return fmt.Errorf("regen: sampler picked %q, which isn't in char class %v", r, rx)
+		}
+		g.separate(w, r)
+		g.endLine(w, r)
+		g.writeRune(w, r)
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		if g.next != anyNext || g.eol || g.Unicode || g.ASCII || g.DotRanges != nil || g.Frequencies != nil ||
+			g.Sampler != nil {
+			// Defer to the char class sampler to pick a rune satisfying the word boundary or line ending, from the
+			// full range of Unicode code points or DotRanges, or by frequency or the Sampler.
+			return g.gen(w, &syntax.Regexp{Op: syntax.OpCharClass, Rune: g.anyRanges(rx.Op)})
+		}
+
+		// The parser only produces OpAnyChar for . in dot-all mode ((?s)), and OpAnyCharNotNL otherwise, so a plain .
+		// never generates a newline here.
+		if rx.Op == syntax.OpAnyChar && g.NewlineProb > 0 && g.randfloat() < g.NewlineProb {
+			if g.NewlineRune != 0 {
+				g.writeRune(w, g.NewlineRune)
+			} else {
+				w.WriteByte('\n')
+			}
+			break
+		}
+		w.WriteRune(rune(' ' + g.randint(95)))
+	case syntax.OpBeginLine:
+		if last, ok := w.lastRune(); ok && last != '\n' {
+			g.separate(w, '\n')
+			g.endLine(w, '\n')
+			w.anchorNewline()
+		}
+	case syntax.OpEndLine:
+		g.eol = true
+	case syntax.OpBeginText:
+		// Nothing may precede the beginning of text, so discard anything generated before it.
+		w.Truncate(0)
+		w.end = -1
+	case syntax.OpEndText:
+		if w.end < 0 {
+			w.end = w.Len()
+		}
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		g.boundary(w, rx.Op)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		if g.deadSubs(rx) {
+			if g.Debug != nil {
+				g.debugf("%v %v: repeat 0 times (can't match anything)", rx.Op, rx)
+			}
+			break
+		}
+		min, max := g.repeatBounds(rx)
+		max = g.capRepeat(min, max)
+		sz := g.repeat(min, max)
+		if g.Debug != nil {
+			g.debugf("%v %v: repeat %d times", rx.Op, rx, sz)
+		}
+		outer := g.nest(sz)
+		for i := 0; i < sz || (i < max && g.short(w)); i++ {
+			if err := g.ctx.Err(); err != nil {
+				return err
+			} else if i >= min && g.full(w) {
+				break
+			}
+			for _, rx := range rx.Sub {
+				if err := g.gen(w, rx); err != nil {
+					return err
+				}
+			}
+		}
+		g.product = outer
+	case syntax.OpQuest:
+		if g.deadSubs(rx) {
+			if g.Debug != nil {
+				g.debugf("%v %v: include false (can't match anything)", rx.Op, rx)
+			}
+			break
+		}
+		if g.Simplify {
+			if n := questChain(rx); n > 1 {
+				return g.genChain(w, rx, n)
+			}
+		}
+		take := !g.full(w) && (g.short(w) || g.randfloat() < g.QuestProb)
+		if g.Debug != nil {
+			g.debugf("%v %v: include %t", rx.Op, rx, take)
+		}
+		if take {
+			for _, rx := range rx.Sub {
+				if err := g.gen(w, rx); err != nil {
+					return err
+				}
+			}
+		}
+	case syntax.OpConcat:
+		for _, rx := range rx.Sub {
+			if err := g.gen(w, rx); err != nil {
+				return err
+			}
+		}
+	case syntax.OpCapture:
+		start := w.Len()
+		for _, rx := range rx.Sub {
+			if err := g.gen(w, rx); err != nil {
+				return err
+			}
+		}
+		if g.captures == nil {
+			g.captures = make(map[int]string)
+		}
+		g.captures[rx.Cap] = w.since(start)
+		if rx.Name != "" {
+			if g.named == nil {
+				g.named = make(map[string]int)
+			}
+			g.named[rx.Name] = rx.Cap
+		}
+	case syntax.OpAlternate:
+		nth := g.alternate(rx)
+		if g.covered != nil {
+			g.taken = append(g.taken, branch{rx, nth})
+		}
+		if g.Debug != nil {
+			g.debugf("%v %v: chose branch %d of %d", rx.Op, rx, nth+1, len(rx.Sub))
+		}
+		return g.gen(w, rx.Sub[nth])
+	}
+
+	return nil
 }
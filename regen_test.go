@@ -0,0 +1,718 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
+	mrand "math/rand"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// mustParse parses pattern with Perl flags, failing the test if it doesn't parse.
+func mustParse(tb testing.TB, pattern string) *syntax.Regexp {
+	tb.Helper()
+	rx, err := Parse(pattern, syntax.Perl)
+	if err != nil {
+		tb.Fatalf("Parse(%q) = %v", pattern, err)
+	}
+	return rx
+}
+
+// seeded returns a Generator with default options drawing from a *math/rand.Rand with the given seed.
+func seeded(seed int64) *Generator {
+	g := New()
+	g.Rand = mrand.New(mrand.NewSource(seed))
+	return g
+}
+
+// benchRepeated is a heavily repeated pattern, so that benchmarks are dominated by random draws.
+const benchRepeated = `(?:[a-z]{10,20}[0-9]*){5}`
+
+func BenchmarkGenerateSeeded(b *testing.B) {
+	g := seeded(1)
+	rx := mustParse(b, benchRepeated)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Generate(rx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateCrypto(b *testing.B) {
+	g := New()
+	g.Rand = rand.Reader
+	rx := mustParse(b, benchRepeated)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Generate(rx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendString(b *testing.B) {
+	for _, pattern := range []string{`[a-z]`, `[a-z]{10}`, benchRepeated} {
+		b.Run(pattern, func(b *testing.B) {
+			g := seeded(1)
+			rx := mustParse(b, pattern)
+			var dst []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if dst, err = g.AppendString(dst[:0], rx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRepeatCounts(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		min, max int
+	}{
+		{`a{3,5}`, 3, 5},
+		{`a{4}`, 4, 4},
+		{`a{0,2}`, 0, 2},
+		{`a{2,}`, 2, 2 + DefaultUnboundMax},
+		{`a*`, 0, DefaultUnboundMax},
+		{`a+`, 1, 1 + DefaultUnboundMax},
+	}
+	for _, dist := range []Distribution{Uniform, Geometric, MaxBiased} {
+		for _, tt := range tests {
+			g := seeded(1)
+			g.Dist = dist
+			rx := mustParse(t, tt.pattern)
+			seen := make(map[int]bool)
+			for i := 0; i < 2000; i++ {
+				s, err := g.Generate(rx)
+				if err != nil {
+					t.Fatalf("%v: Generate(%q) = %v", dist, tt.pattern, err)
+				} else if n := len(s); n < tt.min || n > tt.max {
+					t.Fatalf("%v: Generate(%q) = %q; want %d to %d a's", dist, tt.pattern, s, tt.min, tt.max)
+				}
+				seen[len(s)] = true
+			}
+			// Skewed distributions rarely reach the far end of wide ranges, so only check narrow ones.
+			if tt.max-tt.min <= 2 && (!seen[tt.min] || !seen[tt.max]) {
+				t.Errorf("%v: Generate(%q) never generated the bounds of [%d, %d]: %v",
+					dist, tt.pattern, tt.min, tt.max, seen)
+			}
+		}
+	}
+}
+
+// failingReader is a Rand that reads as fill bytes until n bytes have been read from it, then returns err.
+type failingReader struct {
+	n    int
+	fill byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, r.err
+	}
+	k := min(len(p), r.n)
+	for i := range p[:k] {
+		p[i] = r.fill
+	}
+	r.n -= k
+	return k, nil
+}
+
+func TestRandErrors(t *testing.T) {
+	for _, pattern := range []string{`[a-z]`, `(?:a|b)c`, `x[a-z]*`, `(?:[0-9]+y)*`} {
+		g := New()
+		g.Rand = &failingReader{n: 4, err: io.ErrUnexpectedEOF}
+		rx := mustParse(t, pattern)
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			_, err = g.Generate(rx)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Generate(%q) = %v; want %v", pattern, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestUniformSamplerMalformed(t *testing.T) {
+	randint := func(n int64) int64 {
+		t.Fatalf("randint(%d) called for malformed ranges", n)
+		return 0
+	}
+	for _, ranges := range [][]rune{nil, {}, {'a'}, {'z', 'a'}} {
+		if r := UniformSampler.SampleRune(ranges, randint); r != -1 {
+			t.Errorf("SampleRune(%q) = %q; want -1", ranges, r)
+		}
+	}
+}
+
+func TestStarPropagatesErrors(t *testing.T) {
+	for _, pattern := range []string{`[a-z]*`, `[a-z]+`, `(?:[a-z]|[0-9])*`} {
+		// The first byte draws a repetition count of 5 (or 6, for +), and the first repetition then fails to draw its
+		// rune, so generation must stop there instead of carrying on with the other repetitions.
+		g := New()
+		g.Rand = &failingReader{n: 1, fill: 5, err: io.EOF}
+		s, err := g.Generate(mustParse(t, pattern))
+		if !errors.Is(err, io.EOF) || len(s) > 1 {
+			t.Errorf("Generate(%q) = %q, %v; want at most one rune and %v", pattern, s, err, io.EOF)
+		}
+	}
+}
+
+func TestAlternateGolden(t *testing.T) {
+	// The branches chosen for a seed are part of -seed's reproducibility, so this sequence must not change.
+	want := []string{"ef", "cd", "ab", "ef", "ef", "ef", "ab", "ef"}
+	g := seeded(1)
+	rx := mustParse(t, `(?:ab|cd|ef)`)
+	for i, w := range want {
+		if s, err := g.Generate(rx); err != nil || s != w {
+			t.Fatalf("Generate #%d = %q, %v; want %q", i+1, s, err, w)
+		}
+	}
+}
+
+// countingSource counts the draws taken from a *math/rand.Rand.
+type countingSource struct {
+	*mrand.Rand
+	draws int
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.Rand.Int63()
+}
+
+func TestInt63nUniform(t *testing.T) {
+	const samples, buckets = 200000, 10
+	tests := []struct {
+		name string
+		n    int64
+	}{
+		{"power of two", 8},
+		{"large power of two", 1 << 40},
+		{"small", 7},
+		{"rejecting", 1000},
+		// Nearly half of all 63-bit draws are rejected for n just past 2**62.
+		{"mostly rejecting", 1<<62 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &countingSource{Rand: mrand.New(mrand.NewSource(1))}
+			k := int64(buckets)
+			if tt.n < k {
+				k = tt.n
+			}
+			counts := make([]int, k)
+			for i := 0; i < samples; i++ {
+				v := int63n(src, tt.n)
+				if v < 0 || v >= tt.n {
+					t.Fatalf("int63n(%d) = %d; out of range", tt.n, v)
+				}
+				// Buckets split [0, n) into k nearly equal spans.
+				counts[v/((tt.n+k-1)/k)]++
+			}
+
+			want := float64(samples) / float64(k)
+			for i, c := range counts {
+				if d := float64(c) - want; d > want*0.05 || d < -want*0.05 {
+					t.Errorf("bucket %d of %d has %d draws; want about %.0f", i, k, c, want)
+				}
+			}
+			if tt.n == 1<<62+1 && src.draws < samples*3/2 {
+				t.Errorf("took %d draws for %d samples; want the rejection path to redraw about half",
+					src.draws, samples)
+			}
+		})
+	}
+}
+
+func TestCloneConcurrent(t *testing.T) {
+	// Run with -race: each goroutine generates from its own clone, sharing only read-only options and the regexp.
+	g := New()
+	g.Trace = true
+	g.CycleAlternates = true
+	rx := mustParse(t, `(?P<word>[a-z]{2,8})(?:-(?:x|yy|zzz)){1,3}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		c := g.Clone()
+		c.Rand = mrand.New(mrand.NewSource(int64(i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				s, err := c.Generate(rx)
+				if err != nil {
+					t.Error(err)
+					return
+				} else if word, ok := c.Capture("word"); !ok || !strings.HasPrefix(s, word) {
+					t.Errorf("Capture(word) = %q, %t; want a prefix of %q", word, ok, s)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runeCounts generates n strings from rx and counts the runes in them.
+func runeCounts(t *testing.T, g *Generator, rx *syntax.Regexp, n int) map[rune]int {
+	t.Helper()
+	counts := make(map[rune]int)
+	for i := 0; i < n; i++ {
+		s, err := g.Generate(rx)
+		if err != nil {
+			t.Fatalf("Generate(%v) = %v", rx, err)
+		}
+		for _, r := range s {
+			counts[r]++
+		}
+	}
+	return counts
+}
+
+// checkUniform fails t unless each of want runes was counted within 15% of an equal share of total.
+func checkUniform(t *testing.T, counts map[rune]int, want []rune, total int) {
+	t.Helper()
+	if len(counts) != len(want) {
+		t.Errorf("generated %d distinct runes; want %d", len(counts), len(want))
+	}
+	share := float64(total) / float64(len(want))
+	for _, r := range want {
+		if c := float64(counts[r]); c < share*0.85 || c > share*1.15 {
+			t.Errorf("generated %q %v times; want about %.0f", r, c, share)
+		}
+	}
+}
+
+func TestCharClassUniform(t *testing.T) {
+	const samples = 50000
+	t.Run("negated", func(t *testing.T) {
+		// [^a-z] limited to printable ASCII leaves 69 runes, each of which should be equally likely.
+		g := seeded(1)
+		g.ASCII = true
+		var want []rune
+		for r := rune(' '); r <= '~'; r++ {
+			if r < 'a' || r > 'z' {
+				want = append(want, r)
+			}
+		}
+		checkUniform(t, runeCounts(t, g, mustParse(t, `[^a-z]`), samples), want, samples)
+	})
+	t.Run("overlapping", func(t *testing.T) {
+		// Hand-built ranges may overlap, but the runes they share mustn't be more likely.
+		rx := &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{'a', 'c', 'b', 'd', 'b', 'b'}}
+		checkUniform(t, runeCounts(t, seeded(1), rx, samples), []rune("abcd"), samples)
+	})
+}
+
+func TestPOSIXClasses(t *testing.T) {
+	tests := []struct {
+		class string
+		size  int // Number of runes in the class.
+	}{
+		{`[[:alnum:]]`, 62},
+		{`[[:alpha:]]`, 52},
+		{`[[:blank:]]`, 2},
+		{`[[:cntrl:]]`, 33},
+		{`[[:digit:]]`, 10},
+		{`[[:graph:]]`, 94},
+		{`[[:lower:]]`, 26},
+		{`[[:print:]]`, 95},
+		{`[[:punct:]]`, 32},
+		{`[[:space:]]`, 6},
+		{`[[:upper:]]`, 26},
+		{`[[:word:]]`, 63},
+		{`[[:xdigit:]]`, 22},
+		{`[[:digit:][:upper:]]`, 36},
+		{`[^[:^digit:]]`, 10},
+	}
+	for _, tt := range tests {
+		for _, flags := range []syntax.Flags{syntax.POSIX, syntax.Perl} {
+			rx, err := syntax.Parse(tt.class, flags)
+			if err != nil {
+				t.Fatalf("Parse(%q, %v) = %v", tt.class, flags, err)
+			}
+			re := regexp.MustCompile(`\A` + tt.class + `\z`)
+			g := seeded(1)
+			seen := make(map[string]bool)
+			for i := 0; i < tt.size*50; i++ {
+				s, err := g.Generate(rx)
+				if err != nil {
+					t.Fatalf("Generate(%q) = %v", tt.class, err)
+				} else if !re.MatchString(s) {
+					t.Fatalf("Generate(%q) = %q; doesn't match", tt.class, s)
+				}
+				seen[s] = true
+			}
+			if len(seen) != tt.size {
+				t.Errorf("Generate(%q) with flags %v generated %d distinct strings; want %d",
+					tt.class, flags, len(seen), tt.size)
+			}
+		}
+	}
+}
+
+func TestFoldCaseLiterals(t *testing.T) {
+	g := seeded(1)
+	rx := mustParse(t, `(?i)abc`)
+	re := regexp.MustCompile(`\A(?i)abc\z`)
+	seen := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		s, err := g.Generate(rx)
+		if err != nil {
+			t.Fatalf("Generate = %v", err)
+		} else if !re.MatchString(s) {
+			t.Fatalf("Generate = %q; doesn't match (?i)abc", s)
+		}
+		seen[s] = true
+	}
+	// Each of the 8 cases of abc should turn up, including mixed ones like aBc.
+	if len(seen) != 8 || !seen["aBc"] {
+		t.Errorf("generated %d cases of abc; want all 8: %v", len(seen), seen)
+	}
+}
+
+func TestHugeRepeats(t *testing.T) {
+	rep := func(op syntax.Op, min, max int) *syntax.Regexp {
+		a := &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune{'a'}}
+		return &syntax.Regexp{Op: op, Min: min, Max: max, Sub: []*syntax.Regexp{a}}
+	}
+	tests := []struct {
+		name       string
+		rx         *syntax.Regexp
+		unboundMin int
+		unboundMax int
+		min, max   int // Bounds on the length of the generated string.
+	}{
+		{"huge max", rep(syntax.OpRepeat, 0, 9999999999), 0, 32, 0, 64},
+		{"huge min", rep(syntax.OpRepeat, math.MaxInt, -1), 0, 32, 64, 64},
+		{"negative", rep(syntax.OpRepeat, -5, -3), 0, 32, 0, 0},
+		{"huge unbound max", rep(syntax.OpStar, 0, 0), 0, math.MaxInt, 0, 64},
+		{"huge unbound min", rep(syntax.OpPlus, 0, 0), math.MaxInt, 1, 64, 64},
+	}
+	for _, tt := range tests {
+		g := seeded(1)
+		g.UnboundMin, g.UnboundMax = tt.unboundMin, tt.unboundMax
+		g.MaxRepeat = 64 // Small enough to generate, but the bounds above must still be clamped without overflowing.
+		for i := 0; i < 100; i++ {
+			s, err := g.Generate(tt.rx)
+			if err != nil {
+				t.Fatalf("%s: Generate = %v", tt.name, err)
+			} else if len(s) < tt.min || len(s) > tt.max {
+				t.Fatalf("%s: Generate = %d a's; want %d to %d", tt.name, len(s), tt.min, tt.max)
+			}
+		}
+		if min, max := g.Lengths(tt.rx); min < int64(tt.min) || max > int64(tt.max) || min > max {
+			t.Errorf("%s: Lengths = %d, %d; want within %d to %d", tt.name, min, max, tt.min, tt.max)
+		}
+	}
+}
+
+func TestDotNewlines(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		newlineProb float64
+		newlines    bool // Whether newlines should be generated.
+	}{
+		{`(?s).`, DefaultNewlineProb, true},
+		{`(?s).`, 0, false},
+		{`.`, DefaultNewlineProb, false},
+		{`.`, 1, false},
+		{`(?s:.)|.`, 1, true},
+	}
+	for _, tt := range tests {
+		g := seeded(1)
+		g.NewlineProb = tt.newlineProb
+		newlines := runeCounts(t, g, mustParse(t, tt.pattern), 5000)['\n']
+		if got := newlines > 0; got != tt.newlines {
+			t.Errorf("%q with NewlineProb %v generated %d newlines; want newlines = %t",
+				tt.pattern, tt.newlineProb, newlines, tt.newlines)
+		}
+	}
+}
+
+func TestWordBoundaries(t *testing.T) {
+	for _, pattern := range []string{`\Bfoo`, `foo\B`, `\b-`, `-\b`, `a\b-`, `x\By`, `\b[a-z-]+\b`, `\B\w\B`} {
+		want := regexp.MustCompile(pattern)
+		g := seeded(1)
+		rx := mustParse(t, pattern)
+		for i := 0; i < 100; i++ {
+			s, err := g.Generate(rx)
+			if err != nil {
+				t.Fatalf("Generate(%q) = %v", pattern, err)
+			}
+			if !want.MatchString(s) {
+				t.Fatalf("Generate(%q) = %q; want a match", pattern, s)
+			}
+		}
+	}
+}
+
+func TestCharClassUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		rx   *syntax.Regexp
+		want int // The number of distinct runes the class matches.
+	}{
+		{"emoji", mustParse(t, `[\x{1F600}-\x{1F64F}]`), 0x50},
+		{"astral", mustParse(t, `[\x{10000}-\x{10FFFF}]`), 0},
+		// The parser never produces surrogates, so build classes straddling the surrogate gap by hand.
+		{"surrogate gap", &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{0xD7FE, 0xE001}}, 4},
+		{"surrogates", &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{0xD7FE, 0xD900, 0xDA00, 0xE001}}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := seeded(1)
+			counts := make(map[rune]int)
+			for i := 0; i < 1000; i++ {
+				s, err := g.Generate(tt.rx)
+				if err != nil {
+					t.Fatalf("Generate(%v) = %v", tt.rx, err)
+				}
+				if !utf8.ValidString(s) || utf8.RuneCountInString(s) != 1 {
+					t.Fatalf("Generate(%v) = %q; want one valid rune", tt.rx, s)
+				}
+				r, _ := utf8.DecodeRuneInString(s)
+				if !classHas(tt.rx.Rune, r) {
+					t.Fatalf("Generate(%v) = %q; want a rune in the class", tt.rx, s)
+				}
+				counts[r]++
+			}
+			if tt.want > 0 && len(counts) != tt.want {
+				t.Errorf("Generate(%v) gave %d distinct runes; want %d", tt.rx, len(counts), tt.want)
+			}
+			if n, _ := g.Count(tt.rx); tt.want > 0 && n.Int64() != int64(tt.want) {
+				t.Errorf("Count(%v) = %v; want %d", tt.rx, n, tt.want)
+			}
+		})
+	}
+}
+
+func classHas(ranges []rune, r rune) bool {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] <= r && r <= ranges[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{`abc`, `abc`},
+		{`a(?#comment)b`, `ab`},
+		{`(?#one)a(?#two)`, `a`},
+		{`a(?#(nested)b`, `ab`},
+		{`a(?#unclosed`, `a(?#unclosed`},
+		{`\(?#escaped)`, `\(?#escaped)`},
+		{`[(?#class)]`, `[(?#class)]`},
+		{`[[:alpha:](?#class)]`, `[[:alpha:](?#class)]`},
+		{`[](?#class)]`, `[](?#class)]`},
+		{`\Q(?#quoted)\E(?#comment)`, `\Q(?#quoted)\E`},
+		{`\Q(?#quoted)`, `\Q(?#quoted)`},
+	}
+	for _, tt := range tests {
+		if got := StripComments(tt.pattern); got != tt.want {
+			t.Errorf("StripComments(%q) = %q; want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateComments(t *testing.T) {
+	for _, pattern := range []string{`a(?#comment)b`, `(?#one)[a-z]+(?#two)\d{2}`, `x(?#(nested)(y|z)`} {
+		want := regexp.MustCompile(`^(?:` + StripComments(pattern) + `)$`)
+		g := seeded(1)
+		rx := mustParse(t, pattern)
+		for i := 0; i < 100; i++ {
+			s, err := g.Generate(rx)
+			if err != nil {
+				t.Fatalf("Generate(%q) = %v", pattern, err)
+			}
+			if !want.MatchString(s) {
+				t.Fatalf("Generate(%q) = %q; want a match for %v", pattern, s, want)
+			}
+		}
+	}
+}
+
+func TestReplaceRefs(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+		refs []string
+	}{
+		{`{item}`, "(\U00100000)", []string{"item"}},
+		{`{a},{b}`, "(\U00100000),(\U00100001)", []string{"a", "b"}},
+		{`a{2}`, `a{2}`, nil},
+		{`\{item}`, `\{item}`, nil},
+		{`[{item}]`, `[{item}]`, nil},
+		{`\Q{item}\E{item}`, "\\Q{item}\\E(\U00100000)", []string{"item"}},
+	}
+	for _, tt := range tests {
+		got, refs := replaceRefs(tt.expr, false)
+		if got != tt.want || strings.Join(refs, ",") != strings.Join(tt.refs, ",") {
+			t.Errorf("replaceRefs(%q) = %q, %q; want %q, %q", tt.expr, got, refs, tt.want, tt.refs)
+		}
+	}
+}
+
+func TestGrammar(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules map[string]string
+		flags syntax.Flags
+		match string // A pattern that everything generated from rule a should match.
+	}{
+		{"perl", map[string]string{"a": `x{b}+y`, "b": `[0-9]`}, syntax.Perl, `^x[0-9]+y$`},
+		{"posix", map[string]string{"a": `x{b}+y`, "b": `[0-9]`}, syntax.POSIX, `^x[0-9]+y$`},
+		{"posix repeat", map[string]string{"a": `({b}|-){2}`, "b": `z`}, syntax.POSIX, `^[z-]{2}$`},
+		{"alternates", map[string]string{"a": `{b}|{c}|x{b}|x{c}`, "b": `bb`, "c": `c`}, syntax.Perl, `^x?(bb|c)$`},
+		{"literal", map[string]string{"a": `.{b}.`, "b": `*`}, syntax.Literal, `^\.\*\.$`},
+		{"fold case", map[string]string{"a": `(?i)ab{b}cd`, "b": `e`}, syntax.Perl, `^(?i)abecd$`},
+		{"capture names", map[string]string{"a": `(?P<regenref0>z){b}`, "b": `yy`}, syntax.Perl, `^zyy$`},
+		{"private use", map[string]string{"a": `[\x{100000}-\x{100001}]{b}`, "b": `q`}, syntax.Perl,
+			`^[\x{100000}\x{100001}]q$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr, err := ParseGrammar(tt.rules, tt.flags)
+			if err != nil {
+				t.Fatalf("ParseGrammar(%q) = %v", tt.rules, err)
+			}
+			rx, err := gr.Expand("a", 0)
+			if err != nil {
+				t.Fatalf("Expand(a) = %v", err)
+			}
+			re := regexp.MustCompile(tt.match)
+			g := seeded(1)
+			for i := 0; i < 100; i++ {
+				if s, err := g.Generate(rx); err != nil || !re.MatchString(s) {
+					t.Fatalf("Generate(%v) = %q, %v; want a match for %v", rx, s, err, re)
+				}
+			}
+		})
+	}
+
+	// Runes reserved for references can't be used as literals in rules.
+	if _, err := ParseGrammar(map[string]string{"a": `\x{100000}{a}`}, syntax.Perl); err == nil {
+		t.Errorf("ParseGrammar with a reserved rune = nil; want an error")
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl     string
+		patterns map[string]string
+		match    string // A pattern that everything generated from the template should match.
+	}{
+		{`{user}@{domain}`, map[string]string{"user": `^[a-z]{3}$`, "domain": `[a-z]+\.com`}, `^[a-z]{3}@[a-z]+\.com$`},
+		{`{a}{a}`, map[string]string{"a": `\Ax|y\z`}, `^[xy][xy]$`},
+		{`{{{a}}}`, map[string]string{"a": `(b)(c)?`}, `^\{bc?\}$`},
+	}
+	for _, tt := range tests {
+		rx, err := ParseTemplate(tt.tmpl, tt.patterns, syntax.Perl)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q) = %v", tt.tmpl, err)
+		}
+		re := regexp.MustCompile(tt.match)
+		g := seeded(1)
+		for i := 0; i < 100; i++ {
+			s, err := g.Generate(rx)
+			if err != nil {
+				t.Fatalf("Generate(%v) = %v", rx, err)
+			} else if !re.MatchString(s) {
+				t.Fatalf("template %q generated %q; want a match for %v", tt.tmpl, s, re)
+			}
+		}
+	}
+
+	rx, err := ParseTemplate(`{a}-{b}`, map[string]string{"a": `(x)(y)`, "b": `(z)`}, syntax.Perl)
+	if err != nil {
+		t.Fatalf("ParseTemplate = %v", err)
+	}
+	if got, want := rx.String(), `((x)(y))-((z))`; got != want {
+		t.Errorf("ParseTemplate = %v; want %v", got, want)
+	}
+	if got := rx.MaxCap(); got != 5 {
+		t.Errorf("ParseTemplate has %d capture groups; want 5", got)
+	}
+}
+
+func TestCoverAlternatesContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	strs, err := seeded(1).CoverAlternatesContext(ctx, mustParse(t, `a|b`))
+	if !errors.Is(err, context.Canceled) || len(strs) != 0 {
+		t.Errorf("CoverAlternatesContext(cancelled) = %q, %v; want no strings, %v", strs, err, context.Canceled)
+	}
+}
+
+func TestNormalizeNFC(t *testing.T) {
+	tests := []struct {
+		pattern string
+		nfc     bool
+		want    string
+	}{
+		{`e\x{301}`, false, "e\u0301"},
+		{`e\x{301}`, true, "\u00e9"},
+		{`\x{e9}`, true, "\u00e9"},
+		{`(a)\x{30a}`, true, "\u00e5"},
+	}
+	for _, tt := range tests {
+		g := seeded(1)
+		g.NormalizeNFC = tt.nfc
+		if got, err := g.Generate(mustParse(t, tt.pattern)); err != nil || got != tt.want {
+			t.Errorf("Generate(%q) with NormalizeNFC %t = %q, %v; want %q", tt.pattern, tt.nfc, got, err, tt.want)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// Build a regexp nested deeper than the parser allows.
+	deep := &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune("a")}
+	for i := 0; i < 5000; i++ {
+		deep = &syntax.Regexp{Op: syntax.OpCapture, Cap: i + 1, Sub: []*syntax.Regexp{deep}}
+	}
+	g := seeded(1)
+	g.MaxDepth = 100
+
+	if err := g.CheckDepth(deep); err == nil {
+		t.Errorf("CheckDepth = nil; want an error")
+	}
+	if _, err := g.Generate(deep); err == nil {
+		t.Errorf("Generate = nil error; want an error")
+	}
+	if n, infinite := g.Count(deep); n.Sign() != 0 || infinite {
+		t.Errorf("Count = %v, %t; want 0, false", n, infinite)
+	}
+	if min, max := g.Lengths(deep); min != 0 || max != 0 {
+		t.Errorf("Lengths = %d, %d; want 0, 0", min, max)
+	}
+	if err := g.Enumerate(deep, 0, func(string) error { t.Error("Enumerate called fn"); return nil }); err == nil {
+		t.Errorf("Enumerate = nil; want an error")
+	}
+
+	g.MaxDepth = 0
+	if n, _ := g.Count(deep); n.Int64() != 1 {
+		t.Errorf("Count without MaxDepth = %v; want 1", n)
+	}
+	if err := Check(deep); err != nil {
+		t.Errorf("Check = %v; want nil", err)
+	}
+}
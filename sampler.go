@@ -0,0 +1,40 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+// Sampler picks runes for char classes and . (see Generator.Sampler).
+type Sampler interface {
+	// SampleRune returns a rune from ranges, a sorted list of non-overlapping inclusive rune ranges given as pairs of
+	// low and high bounds. It must use randint, which returns a random integer in the range [0, n), as its source of
+	// randomness so that strings can be reproduced from a seed or Trace.
+	SampleRune(ranges []rune, randint func(n int64) int64) rune
+}
+
+// UniformSampler is a Sampler that picks every rune in a set of ranges with equal probability. It's what the Generator
+// uses if its Sampler is nil. If ranges is empty or malformed, it returns -1, which isn't in any set of ranges, without
+// calling randint.
+var UniformSampler Sampler = uniformSampler{}
+
+type uniformSampler struct{}
+
+func (uniformSampler) SampleRune(ranges []rune, randint func(n int64) int64) rune {
+	if len(ranges) == 0 || !validRanges(ranges) {
+		return -1
+	}
+	return nthRune(ranges, randint(rangesLen(ranges)))
+}
+
+// nthRune returns the rune at index nth of the runes in ranges, counting from the lowest, or -1 if there are nth or
+// fewer runes in ranges.
+func nthRune(ranges []rune, nth int64) rune {
+	for i := 0; i < len(ranges); i += 2 {
+		min, max := ranges[i], ranges[i+1]
+		delta := int64(max - min)
+		if nth <= delta {
+			return min + rune(nth)
+		}
+		nth -= 1 + delta
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// flushSize is the number of buffered bytes at which a streaming sink flushes its buffer.
+const flushSize = 4096
+
+// sink is the destination of generated text. Text is written to a buffer and, if the sink is streaming to a writer,
+// the buffer is flushed to it whenever it grows past flushSize.
+//
+// Positions used by a sink (as returned by Len) are relative to the start of the generated text and include bytes that
+// have already been flushed, so ops that need to know what was generated before them work the same when streaming.
+// Flushed bytes can't be truncated, however.
+type sink struct {
+	buf  *bytes.Buffer
+	base int // Length of buf before generation started.
+	end  int // Position of the earliest end of text, or -1 if not reached. Nothing past it is flushed.
+
+	trim bool // Whether to omit newlines written for line anchors.
+	nl   bool // Whether a newline for a line anchor was omitted since the last byte was written.
+
+	runes int // Number of runes generated, including those flushed.
+
+	w            io.Writer // If not nil, the writer to flush buf to.
+	flushed      int       // Number of bytes flushed to w.
+	flushedRunes int       // Number of runes flushed to w.
+	last         rune      // Last rune flushed to w.
+	err          error     // First error returned by w.
+}
+
+// RuneLen returns the number of runes generated.
+func (s *sink) RuneLen() int {
+	return s.runes
+}
+
+// Len returns the number of bytes generated.
+func (s *sink) Len() int {
+	return s.flushed + s.buf.Len() - s.base
+}
+
+// WriteByte writes a byte to the sink.
+func (s *sink) WriteByte(c byte) error {
+	s.nl = false
+	s.runes++
+	s.buf.WriteByte(c)
+	s.maybeFlush()
+	return nil
+}
+
+// WriteRune writes a UTF-8 encoded rune to the sink.
+func (s *sink) WriteRune(r rune) {
+	s.nl = false
+	s.runes++
+	s.buf.WriteRune(r)
+	s.maybeFlush()
+}
+
+// WriteString writes a string to the sink.
+func (s *sink) WriteString(str string) {
+	if str != "" {
+		s.nl = false
+	}
+	s.runes += utf8.RuneCountInString(str)
+	s.buf.WriteString(str)
+	s.maybeFlush()
+}
+
+// anchorNewline writes a newline needed to satisfy a line anchor. If the sink trims anchor newlines, the newline is
+// omitted, but it is still the last rune generated until something else is written.
+func (s *sink) anchorNewline() {
+	if s.trim {
+		s.nl = true
+		return
+	}
+	s.WriteByte('\n')
+}
+
+// lastRune returns the last rune generated. ok is false if nothing has been generated.
+func (s *sink) lastRune() (r rune, ok bool) {
+	if s.nl {
+		return '\n', true
+	}
+	if b := s.buf.Bytes()[s.base:]; len(b) > 0 {
+		r, _ = utf8.DecodeLastRune(b)
+		return r, true
+	}
+	return s.last, s.flushed > 0
+}
+
+// Truncate discards everything generated after pos. If some of it has already been flushed, only the bytes still
+// buffered are discarded.
+func (s *sink) Truncate(pos int) {
+	s.nl = false
+	s.buf.Truncate(s.base + max(pos-s.flushed, 0))
+	s.runes = s.flushedRunes + utf8.RuneCount(s.buf.Bytes()[s.base:])
+}
+
+// since returns the text generated from pos onwards. If some of it has already been flushed, only the bytes still
+// buffered are returned.
+func (s *sink) since(pos int) string {
+	b := s.buf.Bytes()
+	return string(b[min(s.base+max(pos-s.flushed, 0), len(b)):])
+}
+
+func (s *sink) maybeFlush() {
+	if s.w != nil && s.buf.Len() >= flushSize {
+		s.flush()
+	}
+}
+
+// flush writes buffered bytes to the sink's writer, up to the end of text if it has been reached.
+func (s *sink) flush() error {
+	if s.w == nil || s.err != nil {
+		return s.err
+	}
+
+	b := s.buf.Bytes()
+	if s.end >= 0 {
+		b = b[:min(len(b), max(s.end-s.flushed, 0))]
+	}
+	if len(b) == 0 {
+		return nil
+	}
+
+	s.last, _ = utf8.DecodeLastRune(b)
+	n, err := s.w.Write(b)
+	s.flushed += n
+	s.flushedRunes += utf8.RuneCount(b[:n])
+	s.buf.Next(n)
+	s.err = err
+	return err
+}
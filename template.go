@@ -0,0 +1,94 @@
+// Copyright 2016 Noel Cower. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE.txt file.
+
+package regen
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// ParseTemplate returns a regexp matching the template tmpl, where each {name} placeholder in tmpl is replaced by the
+// pattern bound to name in patterns, parsed using the given syntax flags. Text outside of placeholders is matched
+// literally, and {{ and }} stand for literal braces. Each placeholder becomes a capture group, numbered in the order
+// the placeholders appear, followed by the capture groups inside of it.
+//
+// Each placeholder is parsed, and so generated, independently of the others. Anchors (such as ^ and $) in a
+// placeholder's pattern only mark the start and end of its own value, so they're removed rather than cutting off the
+// rest of the template. Errors parsing a placeholder's pattern are returned as a *ParseError.
+func ParseTemplate(tmpl string, patterns map[string]string, flags syntax.Flags) (*syntax.Regexp, error) {
+	concat := &syntax.Regexp{Op: syntax.OpConcat, Flags: flags}
+	var lit []rune
+	flush := func() {
+		if len(lit) > 0 {
+			concat.Sub = append(concat.Sub, &syntax.Regexp{Op: syntax.OpLiteral, Rune: lit})
+			lit = nil
+		}
+	}
+	for rest := tmpl; rest != ""; {
+		i := strings.IndexAny(rest, "{}")
+		if i == -1 {
+			lit = append(lit, []rune(rest)...)
+			break
+		}
+		lit = append(lit, []rune(rest[:i])...)
+
+		brace := rest[i]
+		rest = rest[i+1:]
+		if rest != "" && rest[0] == brace {
+			// {{ or }}
+			lit = append(lit, rune(brace))
+			rest = rest[1:]
+			continue
+		} else if brace == '}' {
+			return nil, fmt.Errorf("regen: unexpected } in template %q", tmpl)
+		}
+
+		end := strings.IndexByte(rest, '}')
+		if end == -1 {
+			return nil, fmt.Errorf("regen: unclosed placeholder in template %q", tmpl)
+		}
+		name := rest[:end]
+		rest = rest[end+1:]
+
+		pattern, ok := patterns[name]
+		if !ok {
+			return nil, fmt.Errorf("regen: no pattern for placeholder {%s} in template %q", name, tmpl)
+		}
+		rx, err := Parse(pattern, flags)
+		if err != nil {
+			return nil, err
+		}
+		flush()
+		concat.Sub = append(concat.Sub, &syntax.Regexp{Op: syntax.OpCapture, Sub: []*syntax.Regexp{stripAnchors(rx)}})
+	}
+	flush()
+
+	// Number capture groups across the whole template, in the order their opening parentheses appear.
+	ncap := 0
+	var number func(rx *syntax.Regexp)
+	number = func(rx *syntax.Regexp) {
+		if rx.Op == syntax.OpCapture {
+			ncap++
+			rx.Cap = ncap
+		}
+		for _, sub := range rx.Sub {
+			number(sub)
+		}
+	}
+	number(concat)
+	return concat, nil
+}
+
+// stripAnchors replaces the anchors in rx (^, $, \A, and \z) with empty matches and returns rx.
+func stripAnchors(rx *syntax.Regexp) *syntax.Regexp {
+	switch rx.Op {
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		rx.Op = syntax.OpEmptyMatch
+	}
+	for i, sub := range rx.Sub {
+		rx.Sub[i] = stripAnchors(sub)
+	}
+	return rx
+}